@@ -0,0 +1,25 @@
+package filter
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// CacheLineSize is the size in bytes of the cache lines of current x86 and arm64 processors
+const CacheLineSize = 64
+
+var ErrHugePagesUnsupported = errors.New("filter: huge pages are not supported on this platform")
+
+// CacheAlignedUint64s returns n zeroed words whose first one starts a cache line, so that
+// blocks of a multiple of CacheLineSize bytes never straddle two lines.
+// The garbage collector does not move heap objects, the alignment holds for the slice lifetime.
+func CacheAlignedUint64s(n int) []uint64 {
+	const words = CacheLineSize / 8
+	buf := make([]uint64, n+words-1)
+	if len(buf) == 0 {
+		return buf
+	}
+	misalignment := int(uintptr(unsafe.Pointer(&buf[0])) % CacheLineSize / 8)
+	offset := (words - misalignment) % words
+	return buf[offset : offset+n : offset+n]
+}
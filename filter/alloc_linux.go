@@ -0,0 +1,25 @@
+package filter
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// AdviseHugePages asks the kernel to back words with transparent huge pages, which saves
+// most of the TLB misses of random accesses to a large filter. Only the pages lying entirely
+// inside words are advised, it has no effect when transparent huge pages are disabled.
+func AdviseHugePages(words []uint64) error {
+	if len(words) == 0 {
+		return nil
+	}
+	pageSize := uintptr(syscall.Getpagesize())
+	start := uintptr(unsafe.Pointer(&words[0]))
+	end := start + uintptr(len(words))*8
+	first := (start + pageSize - 1) &^ (pageSize - 1)
+	last := end &^ (pageSize - 1)
+	if first >= last {
+		return nil
+	}
+	pages := unsafe.Slice((*byte)(unsafe.Add(unsafe.Pointer(&words[0]), first-start)), last-first)
+	return syscall.Madvise(pages, syscall.MADV_HUGEPAGE)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package filter
+
+// AdviseHugePages is only implemented on Linux, see alloc_linux.go
+func AdviseHugePages(words []uint64) error {
+	return ErrHugePagesUnsupported
+}
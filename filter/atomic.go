@@ -0,0 +1,15 @@
+package filter
+
+import "sync/atomic"
+
+// AtomicOr sets the bits of mask in *addr, concurrently with other AtomicOr calls and atomic
+// loads. sync/atomic only has an Or since go 1.23, so it is a compare and swap loop, which
+// returns without writing when the bits are already set, as most are in a filter being filled.
+func AtomicOr(addr *uint64, mask uint64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if old&mask == mask || atomic.CompareAndSwapUint64(addr, old, old|mask) {
+			return
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package blockedbloom
+
+import (
+	"context"
+
+	"github.com/rag-nar1/Filters/filter"
+)
+
+// InsertParallel inserts keys with opts.WorkerCount() goroutines, each owning a contiguous range
+// of blocks: keys are routed to the worker of their block, which sets its bits without any
+// synchronization or merge. When ctx is cancelled, bf holds an unspecified part of the keys.
+func (bf *BlockedBloomFilter) InsertParallel(ctx context.Context, keys filter.Keys, opts filter.BuildOptions) error {
+	workers := uint64(opts.WorkerCount())
+	route := func(d filter.Digest) int {
+		return int(bf.blockIndex(d.Lo) * workers / bf.BlockCount)
+	}
+	return filter.ParallelBuild(ctx, keys, opts, route, func(_ int, batch []filter.Digest) {
+		for _, d := range batch {
+			bf.InsertDigest(d)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package blockedbloom
+
+import (
+	"sync/atomic"
+
+	"github.com/rag-nar1/Filters/filter"
+	"github.com/zeebo/xxh3"
+)
+
+// ConcurrentBlockedBloomFilter is a BlockedBloomFilter safe for concurrent use without locks:
+// Insert gathers the bits of each word of the block and sets them with one filter.AtomicOr,
+// Exist reads words with atomic loads. A key is only guaranteed to be found by the Exist calls
+// starting after its Insert returned.
+// BlockedBloomFilter stays the faster choice when a single goroutine uses the filter.
+type ConcurrentBlockedBloomFilter struct {
+	bf *BlockedBloomFilter
+}
+
+func NewConcurrentBlockedBloomFilter(n uint64, fpRate float64) *ConcurrentBlockedBloomFilter {
+	return NewBlockedBloomFilter(n, fpRate).Concurrent()
+}
+
+func NewConcurrentBlockedBloomFilterExact(n uint64, fpRate float64) *ConcurrentBlockedBloomFilter {
+	return NewBlockedBloomFilterExact(n, fpRate).Concurrent()
+}
+
+// Concurrent returns a ConcurrentBlockedBloomFilter sharing the blocks of bf, which must not
+// be modified directly afterwards
+func (bf *BlockedBloomFilter) Concurrent() *ConcurrentBlockedBloomFilter {
+	return &ConcurrentBlockedBloomFilter{bf: bf}
+}
+
+func (cbf *ConcurrentBlockedBloomFilter) Insert(data []byte) {
+	cbf.insertHash(xxh3.Hash(data))
+}
+
+func (cbf *ConcurrentBlockedBloomFilter) Exist(data []byte) bool {
+	return cbf.existHash(xxh3.Hash(data))
+}
+
+func (cbf *ConcurrentBlockedBloomFilter) InsertString(s string) {
+	cbf.insertHash(xxh3.HashString(s))
+}
+
+func (cbf *ConcurrentBlockedBloomFilter) ExistString(s string) bool {
+	return cbf.existHash(xxh3.HashString(s))
+}
+
+func (cbf *ConcurrentBlockedBloomFilter) InsertUint64(x uint64) {
+	cbf.InsertDigest(filter.NewDigestUint64(x))
+}
+
+func (cbf *ConcurrentBlockedBloomFilter) ExistUint64(x uint64) bool {
+	return cbf.ExistDigest(filter.NewDigestUint64(x))
+}
+
+func (cbf *ConcurrentBlockedBloomFilter) InsertDigest(d filter.Digest) {
+	cbf.insertHash(d.Lo)
+}
+
+func (cbf *ConcurrentBlockedBloomFilter) ExistDigest(d filter.Digest) bool {
+	return cbf.existHash(d.Lo)
+}
+
+func (cbf *ConcurrentBlockedBloomFilter) insertHash(h uint64) {
+	bf := cbf.bf
+	block := bf.BloomFilters[bf.blockIndex(h)*(bf.BlockBits>>WordSize):][:bf.BlockBits>>WordSize]
+	probes := newProbeStream(h, bf.BitShift)
+
+	var masks [CacheLineBits >> WordSize]uint64
+	for i := uint64(0); i < bf.k; i++ {
+		bitIdx := probes.next()
+		masks[bitIdx>>WordSize] |= 1 << (bitIdx & WordMask)
+	}
+	for w := range block {
+		if masks[w] != 0 {
+			filter.AtomicOr(&block[w], masks[w])
+		}
+	}
+}
+
+func (cbf *ConcurrentBlockedBloomFilter) existHash(h uint64) bool {
+	bf := cbf.bf
+	blockOffset := bf.blockIndex(h) * (bf.BlockBits >> WordSize)
+	probes := newProbeStream(h, bf.BitShift)
+
+	for i := uint64(0); i < bf.k; i++ {
+		bitIdx := probes.next()
+		if atomic.LoadUint64(&bf.BloomFilters[blockOffset+bitIdx>>WordSize])&(1<<(bitIdx&WordMask)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot returns a copy of the filter, it holds every key inserted before the call and
+// possibly some of the keys inserted concurrently
+func (cbf *ConcurrentBlockedBloomFilter) Snapshot() *BlockedBloomFilter {
+	snapshot := *cbf.bf
+	snapshot.BloomFilters = filter.CacheAlignedUint64s(len(cbf.bf.BloomFilters))
+	for i := range snapshot.BloomFilters {
+		snapshot.BloomFilters[i] = atomic.LoadUint64(&cbf.bf.BloomFilters[i])
+	}
+	return &snapshot
+}
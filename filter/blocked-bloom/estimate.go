@@ -0,0 +1,87 @@
+package blockedbloom
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// ErrSaturated is returned by the estimates of unions when every bit of a block of the union
+// is set, its number of keys is then unbounded
+var ErrSaturated = errors.New("blockedbloom: a block has every bit set, the number of keys cannot be estimated")
+
+// EstimatedCount estimates the number of distinct keys inserted from the number of bits set
+// in every block, all the bits of a key being in a single block: the Swamidass and Baldi
+// estimate of a bloom filter, -(B / k) * ln(1 - X / B) for X bits set out of B, is summed
+// over the blocks. It is +Inf once every bit of a block is set.
+func (bf *BlockedBloomFilter) EstimatedCount() float64 {
+	return bf.sumBlocks(nil, func(set int) float64 { return bf.estimate(set) })
+}
+
+func (bf *BlockedBloomFilter) estimate(set int) float64 {
+	b := float64(bf.BlockBits)
+	return -b / float64(bf.k) * math.Log1p(-float64(set)/b)
+}
+
+// sumBlocks sums f of the number of bits set in every block of bf, or of bf | other when
+// other is not nil
+func (bf *BlockedBloomFilter) sumBlocks(other *BlockedBloomFilter, f func(set int) float64) float64 {
+	words := int(bf.BlockBits >> WordSize)
+	sum := 0.0
+	for start := 0; start < len(bf.BloomFilters); start += words {
+		set := 0
+		for i := start; i < start+words; i++ {
+			word := bf.BloomFilters[i]
+			if other != nil {
+				word |= other.BloomFilters[i]
+			}
+			set += bits.OnesCount64(word)
+		}
+		sum += f(set)
+	}
+	return sum
+}
+
+// CurrentFPR returns the false positive rate of the filter as filled: a key absent from the
+// filter is found when its k bits are set in its block, each with probability X / B for X
+// bits set out of B, averaged over the blocks a key can be hashed to.
+func (bf *BlockedBloomFilter) CurrentFPR() float64 {
+	b, k := float64(bf.BlockBits), float64(bf.k)
+	fpr := bf.sumBlocks(nil, func(set int) float64 { return math.Pow(float64(set)/b, k) })
+	return fpr / float64(bf.BlockCount)
+}
+
+// EstimatedUnionCount estimates the number of distinct keys inserted in bf or other, the
+// EstimatedCount of their Union. It returns ErrSaturated instead of +Inf, as do
+// EstimatedIntersectionCount and Jaccard, whose estimates would be NaN.
+func (bf *BlockedBloomFilter) EstimatedUnionCount(other *BlockedBloomFilter) (float64, error) {
+	if err := bf.Compatible(other); err != nil {
+		return 0, err
+	}
+	union := bf.sumBlocks(other, func(set int) float64 { return bf.estimate(set) })
+	if math.IsInf(union, 1) {
+		return 0, ErrSaturated
+	}
+	return union, nil
+}
+
+// EstimatedIntersectionCount estimates the number of distinct keys inserted in both bf and
+// other by inclusion-exclusion: |A| + |B| - |A ∪ B|, which is never negative
+func (bf *BlockedBloomFilter) EstimatedIntersectionCount(other *BlockedBloomFilter) (float64, error) {
+	union, err := bf.EstimatedUnionCount(other)
+	if err != nil {
+		return 0, err
+	}
+	return max(bf.EstimatedCount()+other.EstimatedCount()-union, 0), nil
+}
+
+// Jaccard estimates the Jaccard similarity of the keys of bf and other, |A ∩ B| / |A ∪ B|,
+// it is 0 for two empty filters
+func (bf *BlockedBloomFilter) Jaccard(other *BlockedBloomFilter) (float64, error) {
+	union, err := bf.EstimatedUnionCount(other)
+	if err != nil || union == 0 {
+		return 0, err
+	}
+	intersection, _ := bf.EstimatedIntersectionCount(other)
+	return min(intersection/union, 1), nil
+}
@@ -1,68 +1,263 @@
 package blockedbloom
 
 import (
-	"math"
+	"math/bits"
 
 	"github.com/rag-nar1/Filters/filter"
 	"github.com/zeebo/xxh3"
 )
 
 const (
-	BlockSize      = 256 // in bits
-    WordSize       = 6 // in power of 2
+	BlockSize      = 256 // default block size, in bits
+	WordSize       = 6   // in power of 2
 	Uint64PerBlock = BlockSize >> WordSize
-    WordMask       = 1 << WordSize - 1
+	WordMask       = 1<<WordSize - 1
+	CacheLineBits  = filter.CacheLineSize * 8 // blocks of this size are exactly one cache line
 )
 
 type BlockedBloomFilter struct {
-	BloomFilters []uint64 // 256 bits per block
+	BloomFilters []uint64 // BlockBits bits per block, starting on a cache line so no block straddles two lines
 	k            uint64
+	BlockBits    uint64 // 64, 256 or 512
 	BlockCount   uint64 // in blocks
-	BlockMask    uint64
-	BitMask      uint64
+	BlockMask    uint64 // BlockCount - 1, selects the block when BlockCount is a power of two
+	BitShift     uint64 // 64 - log2(BlockBits)
 }
 
+// NewBlockedBloomFilter picks the block size and the number of hash functions
+// giving the smallest filter whose false positive rate is at most fpRate, see OptimalParameters.
 func NewBlockedBloomFilter(n uint64, fpRate float64) *BlockedBloomFilter {
-	m := uint32(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Log(2) * math.Log(2))))
-	m = filter.NextPowerOfTwo(m)
-    m = max(m, BlockSize)
-	blockCount := uint64(m / BlockSize)
-	bf := &BlockedBloomFilter{
-		BloomFilters: make([]uint64, m>>WordSize),
-		k:            Uint64PerBlock,
+	m, blockBits, k := OptimalParameters(n, fpRate)
+	return NewBlockedBloomFilterWithParams(m, blockBits, k)
+}
+
+// NewBlockedBloomFilterWithParams returns a filter of at least m bits made of blocks of
+// blockBits bits, one of BlockSizes, that sets k bits of a single block per item.
+func NewBlockedBloomFilterWithParams(m uint64, blockBits uint64, k uint64) *BlockedBloomFilter {
+	if !validBlockSize(blockBits) {
+		panic("blockedbloom: block size must be 64, 256 or 512 bits")
+	}
+	blockCount := uint64(filter.NextPowerOfTwo(uint32(max((m+blockBits-1)/blockBits, 1))))
+	return newBlockedBloomFilter(blockCount, blockBits, k)
+}
+
+// NewBlockedBloomFilterWithBlockSize returns the smallest filter of blocks of blockBits bits
+// whose false positive rate is at most fpRate, CacheLineBits gives one cache miss per operation.
+func NewBlockedBloomFilterWithBlockSize(n uint64, fpRate float64, blockBits uint64) *BlockedBloomFilter {
+	if !validBlockSize(blockBits) {
+		panic("blockedbloom: block size must be 64, 256 or 512 bits")
+	}
+	m, k := OptimalParametersForBlockSize(n, fpRate, blockBits)
+	return NewBlockedBloomFilterWithParams(m, blockBits, k)
+}
+
+// NewBlockedBloomFilterExact picks the parameters as NewBlockedBloomFilter but keeps the
+// computed number of blocks instead of rounding it up to a power of two, see OptimalExactParameters.
+func NewBlockedBloomFilterExact(n uint64, fpRate float64) *BlockedBloomFilter {
+	m, blockBits, k := OptimalExactParameters(n, fpRate)
+	return NewBlockedBloomFilterExactWithParams(m, blockBits, k)
+}
+
+// NewBlockedBloomFilterExactWithParams returns a filter of m bits rounded up to a whole block,
+// blocks are then selected with a multiply and shift instead of BlockMask.
+func NewBlockedBloomFilterExactWithParams(m uint64, blockBits uint64, k uint64) *BlockedBloomFilter {
+	if !validBlockSize(blockBits) {
+		panic("blockedbloom: block size must be 64, 256 or 512 bits")
+	}
+	return newBlockedBloomFilter(max((m+blockBits-1)/blockBits, 1), blockBits, k)
+}
+
+func newBlockedBloomFilter(blockCount, blockBits, k uint64) *BlockedBloomFilter {
+	return &BlockedBloomFilter{
+		BloomFilters: filter.CacheAlignedUint64s(int(blockCount * blockBits >> WordSize)),
+		k:            max(k, 1),
+		BlockBits:    blockBits,
 		BlockCount:   blockCount,
 		BlockMask:    blockCount - 1,
-		BitMask:      BlockSize - 1,
+		BitShift:     64 - uint64(bits.TrailingZeros64(blockBits)),
 	}
-	return bf
+}
+
+// AdviseHugePages asks the kernel to back the filter with transparent huge pages,
+// see filter.AdviseHugePages, it returns filter.ErrHugePagesUnsupported outside of Linux.
+func (bf *BlockedBloomFilter) AdviseHugePages() error {
+	return filter.AdviseHugePages(bf.BloomFilters)
+}
+
+// K returns the number of bits set per item
+func (bf *BlockedBloomFilter) K() uint64 {
+	return bf.k
+}
+
+// M returns the size of the filter in bits
+func (bf *BlockedBloomFilter) M() uint64 {
+	return bf.BlockCount * bf.BlockBits
 }
 
 func (bf *BlockedBloomFilter) Insert(data []byte) {
-	hash := xxh3.Hash128(data)
-	blockIdx := hash.Lo & bf.BlockMask
-	blockOffset := blockIdx * Uint64PerBlock
-	h1 := uint64(hash.Hi)
-	h2 := uint64(hash.Hi >> 32)
+	bf.insertHash(xxh3.Hash(data))
+}
+
+func (bf *BlockedBloomFilter) Exist(data []byte) bool {
+	return bf.existHash(xxh3.Hash(data))
+}
+
+// InsertDigest inserts the key of d, the same key as Insert(data) for d = filter.NewDigest(data).
+// Only d.Lo is read, the block is selected by it and its bits by a remix of it, so Insert hashes
+// keys once.
+func (bf *BlockedBloomFilter) InsertDigest(d filter.Digest) {
+	bf.insertHash(d.Lo)
+}
+
+func (bf *BlockedBloomFilter) ExistDigest(d filter.Digest) bool {
+	return bf.existHash(d.Lo)
+}
+
+func (bf *BlockedBloomFilter) insertHash(h uint64) {
+	blockOffset := bf.blockIndex(h) * (bf.BlockBits >> WordSize)
+	probes := newProbeStream(h, bf.BitShift)
 
 	for i := uint64(0); i < bf.k; i++ {
-		bitIdx := (h1 + i*h2) & bf.BitMask
-		bf.BloomFilters[blockOffset + bitIdx >> WordSize] |= 1 << (bitIdx & WordMask)
+		bitIdx := probes.next()
+		bf.BloomFilters[blockOffset+bitIdx>>WordSize] |= 1 << (bitIdx & WordMask)
 	}
-
 }
 
-func (bf *BlockedBloomFilter) Exist(data []byte) bool {
-	hash := xxh3.Hash128(data)
-	blockIdx := hash.Lo & bf.BlockMask
-	blockOffset := blockIdx * Uint64PerBlock
-	h1 := uint64(hash.Hi)
-	h2 := uint64(hash.Hi >> 32)
+func (bf *BlockedBloomFilter) existHash(h uint64) bool {
+	blockOffset := bf.blockIndex(h) * (bf.BlockBits >> WordSize)
+	probes := newProbeStream(h, bf.BitShift)
 
 	for i := uint64(0); i < bf.k; i++ {
-		bitIdx := (h1 + i*h2) & bf.BitMask
-		if bf.BloomFilters[blockOffset + bitIdx >> WordSize] & (1 << (bitIdx & WordMask)) == 0 {
+		bitIdx := probes.next()
+		if bf.BloomFilters[blockOffset+bitIdx>>WordSize]&(1<<(bitIdx&WordMask)) == 0 {
 			return false
 		}
 	}
 	return true
 }
+
+// InsertString inserts the bytes of s without copying them, as Insert([]byte(s))
+func (bf *BlockedBloomFilter) InsertString(s string) {
+	bf.insertHash(xxh3.HashString(s))
+}
+
+func (bf *BlockedBloomFilter) ExistString(s string) bool {
+	return bf.existHash(xxh3.HashString(s))
+}
+
+// InsertUint64 inserts an integer key hashed with filter.NewDigestUint64
+func (bf *BlockedBloomFilter) InsertUint64(x uint64) {
+	bf.InsertDigest(filter.NewDigestUint64(x))
+}
+
+func (bf *BlockedBloomFilter) ExistUint64(x uint64) bool {
+	return bf.ExistDigest(filter.NewDigestUint64(x))
+}
+
+// InsertBatch inserts keys a window at a time: the window is hashed first so the
+// memory accesses of its keys do not wait for each other
+func (bf *BlockedBloomFilter) InsertBatch(keys [][]byte) {
+	var offsets [filter.BatchWindow]uint64
+	var probes [filter.BatchWindow]probeStream
+	for start := 0; start < len(keys); start += filter.BatchWindow {
+		window := keys[start:min(start+filter.BatchWindow, len(keys))]
+		for i, key := range window {
+			h := xxh3.Hash(key)
+			offsets[i] = bf.blockIndex(h) * (bf.BlockBits >> WordSize)
+			probes[i] = newProbeStream(h, bf.BitShift)
+		}
+		for i := range window {
+			for j := uint64(0); j < bf.k; j++ {
+				bitIdx := probes[i].next()
+				bf.BloomFilters[offsets[i]+bitIdx>>WordSize] |= 1 << (bitIdx & WordMask)
+			}
+		}
+	}
+}
+
+// ExistBatch sets results[i] to Exist(keys[i]), results must be at least as long as keys.
+// The window is hashed first, then the first probed word of every block is loaded, these
+// independent loads overlap their cache misses before the remaining probes are checked.
+func (bf *BlockedBloomFilter) ExistBatch(keys [][]byte, results []bool) {
+	_ = results[:len(keys)]
+	var offsets [filter.BatchWindow]uint64
+	var probes [filter.BatchWindow]probeStream
+	var first [filter.BatchWindow]uint64
+	for start := 0; start < len(keys); start += filter.BatchWindow {
+		window := keys[start:min(start+filter.BatchWindow, len(keys))]
+		for i, key := range window {
+			h := xxh3.Hash(key)
+			offsets[i] = bf.blockIndex(h) * (bf.BlockBits >> WordSize)
+			probes[i] = newProbeStream(h, bf.BitShift)
+		}
+		for i := range window {
+			bitIdx := probes[i].next()
+			first[i] = bf.BloomFilters[offsets[i]+bitIdx>>WordSize] >> (bitIdx & WordMask) & 1
+		}
+		for i := range window {
+			exist := first[i] == 1
+			for j := uint64(1); exist && j < bf.k; j++ {
+				bitIdx := probes[i].next()
+				exist = bf.BloomFilters[offsets[i]+bitIdx>>WordSize]&(1<<(bitIdx&WordMask)) != 0
+			}
+			results[start+i] = exist
+		}
+	}
+}
+
+// blockIndex maps h to [0, BlockCount) with a mask, or Lemire's multiply and shift when
+// the number of blocks is not a power of two
+func (bf *BlockedBloomFilter) blockIndex(h uint64) uint64 {
+	if bf.BlockCount&bf.BlockMask == 0 {
+		return h & bf.BlockMask
+	}
+	hi, _ := bits.Mul64(h, bf.BlockCount)
+	return hi
+}
+
+// probeStream cuts independent bit indexes of a block out of a remix of the hash, log2(BlockBits)
+// bits at a time, and remixes it again once its bits are used up. The hash itself selects the
+// block, the remix keeps the bits independent of the block index.
+// Double hashing h1 + i*h2 would only give BlockBits^2 distinct patterns per block,
+// which bounds the false positive rate far above the one of independent probes.
+type probeStream struct {
+	seed  uint64
+	h     uint64
+	left  uint64 // unused bits of h
+	shift uint64
+}
+
+func newProbeStream(h, shift uint64) probeStream {
+	seed := filter.Mix64(h)
+	return probeStream{seed: seed, h: seed, left: 64, shift: shift}
+}
+
+func (p *probeStream) next() uint64 {
+	width := 64 - p.shift
+	if p.left < width {
+		p.seed = filter.Mix64(p.seed)
+		p.h, p.left = p.seed, 64
+	}
+	bitIdx := p.h >> p.shift
+	p.h <<= width
+	p.left -= width
+	return bitIdx
+}
+
+// AppendHash appends the indexes in the whole filter of the k bits of data to dst,
+// reusing dst avoids any allocation
+func (bf *BlockedBloomFilter) AppendHash(dst []int, data []byte) []int {
+	h := xxh3.Hash(data)
+	blockStart := bf.blockIndex(h) * bf.BlockBits
+	probes := newProbeStream(h, bf.BitShift)
+
+	for i := uint64(0); i < bf.k; i++ {
+		dst = append(dst, int(blockStart+probes.next()))
+	}
+	return dst
+}
+
+// ExpectedFPR returns the false positive rate of the filter once n items are inserted
+func (bf *BlockedBloomFilter) ExpectedFPR(n uint64) float64 {
+	return FalsePositiveRate(n, bf.M(), bf.BlockBits, bf.k)
+}
@@ -1,12 +1,20 @@
 package blockedbloom_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/bits-and-blooms/bloom/v3"
+	"github.com/rag-nar1/Filters/filter"
 	blockedbloom "github.com/rag-nar1/Filters/filter/blocked-bloom"
 )
 
@@ -36,7 +44,6 @@ func TestBenchmarkMetrics(t *testing.T) {
 			itemsToInsert[j] = []byte(fmt.Sprintf("inserted_%d_%d", i, j))
 		}
 
-
 		startInsert := time.Now()
 		for _, item := range itemsToInsert {
 			bf.Insert(item)
@@ -90,7 +97,6 @@ func TestBenchmarkMetrics(t *testing.T) {
 			itemsToInsert[j] = []byte(fmt.Sprintf("inserted_%d_%d", i, j))
 		}
 
-
 		startInsert := time.Now()
 		for _, item := range itemsToInsert {
 			bloomFilter.Add(item)
@@ -153,4 +159,631 @@ func BenchmarkSeparateTestAndAddBloom(b *testing.B) {
 		f.Test(key)
 		f.Add(key)
 	}
-}
\ No newline at end of file
+}
+
+// with the low 32 bits of the hash equal to 1, word i of the block gets bit SBBFSalts[i] >> 27,
+// and the high 32 bits equal to 2^32-1 select the last block
+func TestSplitBlockLayout(t *testing.T) {
+	sbf := blockedbloom.NewSplitBlockFilterWithBytes(4 * blockedbloom.SBBFBlockBytes)
+	sbf.InsertHash(0xffffffff_00000001)
+
+	expected := make([]byte, 4*blockedbloom.SBBFBlockBytes)
+	for i, bit := range []uint{8, 8, 17, 20, 14, 5, 19, 11} {
+		binary.LittleEndian.PutUint32(expected[3*blockedbloom.SBBFBlockBytes+4*i:], 1<<bit)
+	}
+	if bitset := sbf.Bitset(); !bytes.Equal(bitset, expected) {
+		t.Fatalf("unexpected bitset\n%x\nexpected\n%x", bitset, expected)
+	}
+	if !sbf.ExistHash(0xffffffff_00000001) || sbf.ExistHash(0xffffffff_00000002) {
+		t.Error("unexpected membership")
+	}
+}
+
+func TestSplitBlockFilter(t *testing.T) {
+	const n = 100000
+	const fpRate = 0.01
+	sbf := blockedbloom.NewSplitBlockFilter(n, fpRate)
+	numBytes := len(sbf.Blocks) * blockedbloom.SBBFBlockBytes
+	if numBytes&(numBytes-1) != 0 || numBytes < blockedbloom.SBBFMinBytes || numBytes > blockedbloom.SBBFMaxBytes {
+		t.Fatalf("invalid bitset size %d", numBytes)
+	}
+
+	for i := 0; i < n; i++ {
+		sbf.Insert([]byte(fmt.Sprintf("inserted_%d", i)))
+	}
+	for i := 0; i < n; i++ {
+		if !sbf.Exist([]byte(fmt.Sprintf("inserted_%d", i))) {
+			t.Fatalf("false negative for inserted_%d", i)
+		}
+	}
+	fpCount := 0
+	for i := 0; i < n; i++ {
+		if sbf.Exist([]byte(fmt.Sprintf("not_inserted_%d", i))) {
+			fpCount++
+		}
+	}
+	// the size is rounded up to a power of two, so the rate is at most the target
+	if rate := float64(fpCount) / n; rate > fpRate*1.1 {
+		t.Errorf("false positive rate too high: %f", rate)
+	}
+
+	// a parquet reader gets the same filter from the exported bitset
+	imported, err := blockedbloom.NewSplitBlockFilterFromBitset(sbf.Bitset())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(imported.Bitset(), sbf.Bitset()) {
+		t.Fatal("bitset mismatch after import")
+	}
+	if !imported.Exist([]byte("inserted_0")) {
+		t.Error("false negative after import")
+	}
+
+	for _, size := range []int{0, 31, 33} {
+		if _, err := blockedbloom.NewSplitBlockFilterFromBitset(make([]byte, size)); err != blockedbloom.ErrInvalidBitset {
+			t.Errorf("size %d: expected ErrInvalidBitset, got %v", size, err)
+		}
+	}
+}
+
+// measured false positive rates must stay close to the blocked bloom formula, and
+// so below the target of filters sized from it
+func TestFalsePositiveRate(t *testing.T) {
+	const n = 100000
+	const queries = 1000000
+
+	measure := func(bf *blockedbloom.BlockedBloomFilter) float64 {
+		for i := 0; i < n; i++ {
+			bf.Insert([]byte(fmt.Sprintf("inserted_%d", i)))
+		}
+		fpCount := 0
+		for i := 0; i < queries; i++ {
+			if bf.Exist([]byte(fmt.Sprintf("not_inserted_%d", i))) {
+				fpCount++
+			}
+		}
+		return float64(fpCount) / queries
+	}
+	// relative tolerance plus 4 standard deviations of the measured rate
+	within := func(measured, expected float64) bool {
+		return measured <= expected*1.15+4*math.Sqrt(expected/queries)
+	}
+
+	for _, fpRate := range []float64{0.05, 0.01, 0.001, 0.0001} {
+		bf := blockedbloom.NewBlockedBloomFilter(n, fpRate)
+		measured := measure(bf)
+		t.Logf("target %g: block %d bits, k=%d, %.2f bits per item, measured %g, expected %g",
+			fpRate, bf.BlockBits, bf.K(), float64(bf.M())/n, measured, bf.ExpectedFPR(n))
+		if !within(measured, fpRate) {
+			t.Errorf("target %g: measured false positive rate %g", fpRate, measured)
+		}
+	}
+
+	for _, blockBits := range blockedbloom.BlockSizes {
+		for _, k := range []uint64{2, 4, 8} {
+			bf := blockedbloom.NewBlockedBloomFilterWithParams(10*n, blockBits, k)
+			measured := measure(bf)
+			expected := bf.ExpectedFPR(n)
+			t.Logf("block %d bits, k=%d: measured %g, expected %g", blockBits, k, measured, expected)
+			if !within(measured, expected) {
+				t.Errorf("block %d bits, k=%d: measured false positive rate %g, expected %g", blockBits, k, measured, expected)
+			}
+		}
+	}
+}
+
+func TestExactSizing(t *testing.T) {
+	const n = 100000
+	const fpRate = 0.001
+	bf := blockedbloom.NewBlockedBloomFilterExact(n, fpRate)
+	rounded := blockedbloom.NewBlockedBloomFilter(n, fpRate)
+	if bf.BlockCount&(bf.BlockCount-1) == 0 || bf.M() >= rounded.M() {
+		t.Fatalf("expected an exact block count below %d bits, got %d blocks", rounded.M(), bf.BlockCount)
+	}
+
+	for i := 0; i < n; i++ {
+		bf.Insert([]byte(fmt.Sprintf("inserted_%d", i)))
+	}
+	for i := 0; i < n; i++ {
+		if !bf.Exist([]byte(fmt.Sprintf("inserted_%d", i))) {
+			t.Fatalf("false negative for inserted_%d", i)
+		}
+	}
+	fpCount := 0
+	for i := 0; i < 10*n; i++ {
+		if bf.Exist([]byte(fmt.Sprintf("not_inserted_%d", i))) {
+			fpCount++
+		}
+	}
+	if rate := float64(fpCount) / (10 * n); rate > fpRate*1.2 {
+		t.Errorf("false positive rate too high: %f (expected <= %f)", rate, fpRate)
+	}
+}
+
+// BenchmarkExactSizing compares filters whose block count is rounded up to a power of two
+// with exactly sized ones, whose blocks are selected with a multiply and shift
+func BenchmarkExactSizing(b *testing.B) {
+	const fpRate = 0.001
+	key := make([]byte, 100)
+	for _, n := range []uint64{100000, 1000000, 3000000} {
+		rounded := blockedbloom.NewBlockedBloomFilter(n, fpRate)
+		exact := blockedbloom.NewBlockedBloomFilterExact(n, fpRate)
+
+		b.Run(fmt.Sprintf("n=%d/PowerOfTwo", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				binary.BigEndian.PutUint32(key, uint32(i))
+				rounded.Insert(key)
+				rounded.Exist(key)
+			}
+			b.ReportMetric(float64(rounded.M())/8/1024, "memory_KB")
+		})
+		b.Run(fmt.Sprintf("n=%d/Exact", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				binary.BigEndian.PutUint32(key, uint32(i))
+				exact.Insert(key)
+				exact.Exist(key)
+			}
+			b.ReportMetric(float64(exact.M())/8/1024, "memory_KB")
+			b.ReportMetric((1-float64(exact.M())/float64(rounded.M()))*100, "memory_saved_%")
+		})
+	}
+}
+
+// the bits of a block are cut out of the hash independently, so the probes of a key only
+// collide by chance, unlike double hashing whose step may cycle over a few bits of the block
+func TestDistinctProbes(t *testing.T) {
+	const keys = 100000
+	for _, blockBits := range blockedbloom.BlockSizes {
+		const k = 8
+		bf := blockedbloom.NewBlockedBloomFilterWithParams(1<<20, blockBits, k)
+		buf := make([]int, 0, k)
+		total := 0
+		for i := 0; i < keys; i++ {
+			buf = bf.AppendHash(buf[:0], []byte(fmt.Sprintf("key_%d", i)))
+			seen := map[int]struct{}{}
+			for _, idx := range buf {
+				seen[idx] = struct{}{}
+			}
+			total += len(seen)
+		}
+
+		// expected number of distinct values among k independent draws out of blockBits
+		b := float64(blockBits)
+		expected := b * (1 - math.Pow(1-1/b, k))
+		average := float64(total) / keys
+		t.Logf("block %d bits: %.4f distinct probes, independent draws give %.4f", blockBits, average, expected)
+		if math.Abs(average-expected) > 0.01 {
+			t.Errorf("block %d bits: %.4f distinct probes, expected %.4f", blockBits, average, expected)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	const n = 10000
+	batch := blockedbloom.NewBlockedBloomFilter(n, 0.01)
+	single := blockedbloom.NewBlockedBloomFilterWithParams(batch.M(), batch.BlockBits, batch.K())
+
+	keys := make([][]byte, 2*n+5) // not a multiple of the window
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key_%d", i))
+	}
+	batch.InsertBatch(keys[:n])
+	for _, key := range keys[:n] {
+		single.Insert(key)
+	}
+	for i := range batch.BloomFilters {
+		if batch.BloomFilters[i] != single.BloomFilters[i] {
+			t.Fatalf("word %d differs between InsertBatch and Insert", i)
+		}
+	}
+
+	results := make([]bool, len(keys))
+	batch.ExistBatch(keys, results)
+	for i, key := range keys {
+		if results[i] != batch.Exist(key) {
+			t.Fatalf("ExistBatch and Exist disagree on %s", key)
+		}
+		if i < n && !results[i] {
+			t.Fatalf("false negative for %s", key)
+		}
+	}
+}
+
+// BenchmarkBatch compares InsertBatch and ExistBatch with loops over Insert and Exist on a
+// filter much larger than the caches, where every block is a cache miss
+func BenchmarkBatch(b *testing.B) {
+	bf := blockedbloom.NewBlockedBloomFilter(1<<25, 0.01)
+	keys := make([][]byte, 1<<16)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("batch_key_%d", i))
+	}
+	results := make([]bool, len(keys))
+
+	b.Run("Insert", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bf.Insert(keys[i%len(keys)])
+		}
+	})
+	b.Run("InsertBatch", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i += len(keys) {
+			bf.InsertBatch(keys[:min(len(keys), b.N-i)])
+		}
+	})
+	b.Run("Exist", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			results[i%len(keys)] = bf.Exist(keys[i%len(keys)])
+		}
+	})
+	b.Run("ExistBatch", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i += len(keys) {
+			bf.ExistBatch(keys[:min(len(keys), b.N-i)], results)
+		}
+	})
+}
+
+func TestCacheAlignment(t *testing.T) {
+	for _, n := range []uint64{1, 100, 12345, 1 << 20} {
+		for _, blockBits := range blockedbloom.BlockSizes {
+			bf := blockedbloom.NewBlockedBloomFilterWithBlockSize(n, 0.01, blockBits)
+			if addr := uintptr(unsafe.Pointer(&bf.BloomFilters[0])); addr%filter.CacheLineSize != 0 {
+				t.Fatalf("n=%d blockBits=%d: storage at %#x is not cache line aligned", n, blockBits, addr)
+			}
+			if bf.BlockBits != blockBits {
+				t.Fatalf("expected blocks of %d bits, got %d", blockBits, bf.BlockBits)
+			}
+			if fpr := bf.ExpectedFPR(n); fpr > 0.01 {
+				t.Errorf("n=%d blockBits=%d: expected fpr at most 0.01, got %f", n, blockBits, fpr)
+			}
+		}
+	}
+
+	bf := blockedbloom.NewBlockedBloomFilterWithBlockSize(1<<20, 0.01, blockedbloom.CacheLineBits)
+	if err := bf.AdviseHugePages(); err != nil {
+		// transparent huge pages may be disabled, the filter must work regardless
+		t.Logf("AdviseHugePages: %v", err)
+	}
+	for i := uint64(0); i < 1<<20; i++ {
+		bf.InsertUint64(i)
+	}
+	for i := uint64(0); i < 1<<20; i++ {
+		if !bf.ExistUint64(i) {
+			t.Fatalf("false negative for %d", i)
+		}
+	}
+}
+
+// BenchmarkCacheAlignment compares lookups in cache line aligned storage with storage shifted
+// by one word, where 256 bits blocks straddle two lines half of the time and 512 bits blocks
+// always do, on a filter much larger than the caches
+func BenchmarkCacheAlignment(b *testing.B) {
+	n := uint64(1 << 25)
+	for _, blockBits := range []uint64{256, blockedbloom.CacheLineBits} {
+		bf := blockedbloom.NewBlockedBloomFilterWithBlockSize(n, 0.01, blockBits)
+		for i := uint64(0); i < n; i += 2 {
+			bf.InsertUint64(i)
+		}
+		aligned := bf.BloomFilters
+		misaligned := filter.CacheAlignedUint64s(len(aligned) + 1)[1:]
+		copy(misaligned, aligned)
+		hugePages := filter.CacheAlignedUint64s(len(aligned))
+		copy(hugePages, aligned)
+		if err := filter.AdviseHugePages(hugePages); err != nil {
+			hugePages = nil
+		}
+
+		for _, storage := range []struct {
+			name  string
+			words []uint64
+		}{{"Aligned", aligned}, {"Misaligned", misaligned}, {"AlignedHugePages", hugePages}} {
+			if storage.words == nil {
+				continue
+			}
+			bf.BloomFilters = storage.words
+			straddling := 0.0
+			if offset := uintptr(unsafe.Pointer(&storage.words[0])) % filter.CacheLineSize; offset != 0 {
+				// blocks start at offset + i*blockBytes modulo a line
+				blockBytes := blockBits / 8
+				lines := uint64(filter.CacheLineSize) / min(blockBytes, filter.CacheLineSize)
+				for i := uint64(0); i < lines; i++ {
+					if (uint64(offset)+i*blockBytes)%filter.CacheLineSize+blockBytes > filter.CacheLineSize {
+						straddling++
+					}
+				}
+				straddling = straddling / float64(lines) * 100
+			}
+
+			b.Run(fmt.Sprintf("blockBits=%d/%s", blockBits, storage.name), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					bf.ExistUint64(uint64(i) * 0x9e3779b97f4a7c15)
+				}
+				b.ReportMetric(straddling, "straddling_%")
+			})
+		}
+		bf.BloomFilters = aligned
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	const goroutines, perGoroutine = 16, 20000
+	for _, blockBits := range blockedbloom.BlockSizes {
+		bf := blockedbloom.NewBlockedBloomFilterWithBlockSize(goroutines*perGoroutine, 0.01, blockBits)
+		sequential := blockedbloom.NewBlockedBloomFilterWithParams(bf.M(), bf.BlockBits, bf.K())
+		cbf := bf.Concurrent()
+
+		var wg sync.WaitGroup
+		errs := make(chan string, goroutines)
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < perGoroutine; i++ {
+					key := fmt.Sprintf("key_%d_%d", g, i)
+					cbf.InsertString(key)
+					// a key must be found as soon as its Insert returned, whatever the other writers do
+					if !cbf.ExistString(key) {
+						errs <- "false negative for " + key
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("blockBits=%d: %s", blockBits, err)
+		}
+
+		for g := 0; g < goroutines; g++ {
+			for i := 0; i < perGoroutine; i++ {
+				key := []byte(fmt.Sprintf("key_%d_%d", g, i))
+				if !cbf.Exist(key) {
+					t.Fatalf("blockBits=%d: false negative for %s", blockBits, key)
+				}
+				sequential.Insert(key)
+			}
+		}
+		// no bit was lost by concurrent writers of the same word
+		snapshot := cbf.Snapshot()
+		for i := range sequential.BloomFilters {
+			if snapshot.BloomFilters[i] != sequential.BloomFilters[i] {
+				t.Fatalf("blockBits=%d: word %d differs from sequential insertion", blockBits, i)
+			}
+		}
+	}
+}
+
+// BenchmarkConcurrent compares the lock free filter with a BlockedBloomFilter behind a mutex,
+// and with the plain BlockedBloomFilter when a single goroutine inserts
+func BenchmarkConcurrent(b *testing.B) {
+	n := uint64(1 << 24)
+	b.Run("Single/BlockedBloomFilter", func(b *testing.B) {
+		bf := blockedbloom.NewBlockedBloomFilter(n, 0.01)
+		for i := 0; i < b.N; i++ {
+			bf.InsertUint64(uint64(i))
+		}
+	})
+	b.Run("Single/Concurrent", func(b *testing.B) {
+		cbf := blockedbloom.NewConcurrentBlockedBloomFilter(n, 0.01)
+		for i := 0; i < b.N; i++ {
+			cbf.InsertUint64(uint64(i))
+		}
+	})
+	b.Run("Parallel/Mutex", func(b *testing.B) {
+		bf := blockedbloom.NewBlockedBloomFilter(n, 0.01)
+		var mu sync.Mutex
+		var next atomic.Uint64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				key := next.Add(1)
+				mu.Lock()
+				bf.InsertUint64(key)
+				mu.Unlock()
+			}
+		})
+	})
+	b.Run("Parallel/Concurrent", func(b *testing.B) {
+		cbf := blockedbloom.NewConcurrentBlockedBloomFilter(n, 0.01)
+		var next atomic.Uint64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				cbf.InsertUint64(next.Add(1))
+			}
+		})
+	})
+}
+
+func TestInsertParallel(t *testing.T) {
+	n := 100000
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key_%d", i))
+	}
+	for _, parallel := range []*blockedbloom.BlockedBloomFilter{
+		blockedbloom.NewBlockedBloomFilter(uint64(n), 0.01),
+		blockedbloom.NewBlockedBloomFilterExact(uint64(n), 0.001),
+	} {
+		sequential := blockedbloom.NewBlockedBloomFilterExactWithParams(parallel.M(), parallel.BlockBits, parallel.K())
+		for _, key := range keys {
+			sequential.Insert(key)
+		}
+
+		// keys from a channel, as produced by a reader
+		ch := make(chan []byte)
+		go func() {
+			for _, key := range keys {
+				ch <- key
+			}
+			close(ch)
+		}()
+		if err := parallel.InsertParallel(context.Background(), filter.KeysFromChannel(ch), filter.BuildOptions{Workers: 5}); err != nil {
+			t.Fatal(err)
+		}
+		for i := range sequential.BloomFilters {
+			if parallel.BloomFilters[i] != sequential.BloomFilters[i] {
+				t.Fatalf("word %d differs from sequential insertion", i)
+			}
+		}
+	}
+}
+
+func TestUnion(t *testing.T) {
+	const n, nodes = 30000, 3
+	for _, template := range []*blockedbloom.BlockedBloomFilter{
+		blockedbloom.NewBlockedBloomFilter(n, 0.01),
+		blockedbloom.NewBlockedBloomFilterExact(n, 0.01),
+	} {
+		whole := template.Clone()
+		partials := make([]*blockedbloom.BlockedBloomFilter, nodes)
+		for i := range partials {
+			partials[i] = template.Clone()
+		}
+		for i := 0; i < n; i++ {
+			whole.InsertUint64(uint64(i))
+			partials[i%nodes].InsertUint64(uint64(i))
+		}
+
+		union, err := blockedbloom.Union(partials...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !union.Equal(whole) {
+			t.Fatal("the union of the partial filters differs from the filter of all the keys")
+		}
+		if uintptr(unsafe.Pointer(&union.BloomFilters[0]))%filter.CacheLineSize != 0 {
+			t.Fatal("the union is not cache aligned")
+		}
+		for _, p := range partials[1:] {
+			if err := partials[0].Union(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !partials[0].Equal(whole) {
+			t.Fatal("the in place union differs from the filter of all the keys")
+		}
+
+		// the keys of both filters survive an intersection
+		even := template.Clone()
+		for i := 0; i < n; i += 2 {
+			even.InsertUint64(uint64(i))
+		}
+		if err := even.Intersect(partials[1]); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if i%2 == 0 && i%nodes == 1 && !even.ExistUint64(uint64(i)) {
+				t.Fatalf("key %d of both filters lost by the intersection", i)
+			}
+		}
+
+		whole.Clear()
+		if !whole.Equal(template) {
+			t.Fatal("Clear left bits set")
+		}
+	}
+
+	a := blockedbloom.NewBlockedBloomFilterWithParams(1<<16, 256, 8)
+	for _, b := range []*blockedbloom.BlockedBloomFilter{
+		blockedbloom.NewBlockedBloomFilterWithParams(1<<16, 512, 8),
+		blockedbloom.NewBlockedBloomFilterWithParams(1<<17, 256, 8),
+		blockedbloom.NewBlockedBloomFilterWithParams(1<<16, 256, 7),
+	} {
+		if err := a.Union(b); !errors.Is(err, blockedbloom.ErrIncompatible) {
+			t.Fatalf("expected ErrIncompatible, got %v", err)
+		}
+	}
+}
+
+func TestEstimates(t *testing.T) {
+	const n = 50000
+	for _, blockBits := range blockedbloom.BlockSizes {
+		template := blockedbloom.NewBlockedBloomFilterWithBlockSize(n, 0.01, blockBits)
+		a, b := template.Clone(), template.Clone()
+		// a holds [0, 30000), b holds [20000, 50000): 10000 common keys out of 50000
+		for i := 0; i < 30000; i++ {
+			a.InsertUint64(uint64(i))
+			b.InsertUint64(uint64(i + 20000))
+		}
+
+		within := func(name string, got, want, tolerance float64) {
+			t.Helper()
+			if math.Abs(got-want) > tolerance*want {
+				t.Errorf("%d bits blocks, %s: estimated %.4f, actual %.4f", blockBits, name, got, want)
+			}
+		}
+		within("count", a.EstimatedCount(), 30000, 0.02)
+		union, err := a.EstimatedUnionCount(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		within("union", union, 50000, 0.02)
+		intersection, _ := a.EstimatedIntersectionCount(b)
+		within("intersection", intersection, 10000, 0.1)
+		jaccard, _ := a.Jaccard(b)
+		within("jaccard", jaccard, 0.2, 0.1)
+
+		falsePositives := 0
+		for i := n; i < 11*n; i++ {
+			if a.ExistUint64(uint64(i)) {
+				falsePositives++
+			}
+		}
+		within("false positive rate", a.CurrentFPR(), float64(falsePositives)/(10*n), 0.2)
+
+		if count := template.EstimatedCount(); count != 0 {
+			t.Errorf("empty filter estimated to hold %v keys", count)
+		}
+	}
+}
+
+func TestEstimatesSaturated(t *testing.T) {
+	saturated := blockedbloom.NewBlockedBloomFilter(100, 0.1)
+	for i := 0; i < 100000; i++ {
+		saturated.InsertUint64(uint64(i))
+	}
+	if count := saturated.EstimatedCount(); !math.IsInf(count, 1) {
+		t.Fatalf("expected +Inf keys in a saturated filter, got %v", count)
+	}
+	empty := saturated.Clone()
+	empty.Clear()
+	// the estimates of unions with a saturated filter are errors rather than +Inf or NaN
+	for _, pair := range [][2]*blockedbloom.BlockedBloomFilter{{saturated, saturated}, {saturated, empty}, {empty, saturated}} {
+		if _, err := pair[0].EstimatedUnionCount(pair[1]); !errors.Is(err, blockedbloom.ErrSaturated) {
+			t.Errorf("union: expected ErrSaturated, got %v", err)
+		}
+		if _, err := pair[0].EstimatedIntersectionCount(pair[1]); !errors.Is(err, blockedbloom.ErrSaturated) {
+			t.Errorf("intersection: expected ErrSaturated, got %v", err)
+		}
+		if _, err := pair[0].Jaccard(pair[1]); !errors.Is(err, blockedbloom.ErrSaturated) {
+			t.Errorf("jaccard: expected ErrSaturated, got %v", err)
+		}
+	}
+}
+
+// BenchmarkNewBlockedBloomFilter measures the parameters of the constructors, searched once per
+// false positive rate then cached, without the allocation of bits
+func BenchmarkNewBlockedBloomFilter(b *testing.B) {
+	for _, fpRate := range []float64{0.01, 0.0001} {
+		b.Run(fmt.Sprintf("OptimalParameters/fpRate=%g", fpRate), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				blockedbloom.OptimalParameters(1000000, fpRate)
+			}
+		})
+		b.Run(fmt.Sprintf("OptimalExactParameters/fpRate=%g", fpRate), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				blockedbloom.OptimalExactParameters(1000000, fpRate)
+			}
+		})
+		b.Run(fmt.Sprintf("NewBlockedBloomFilter/fpRate=%g", fpRate), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				blockedbloom.NewBlockedBloomFilter(1000, fpRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package blockedbloom
+
+import (
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/rag-nar1/Filters/filter"
+)
+
+const (
+	MaxK           = 24  // bounds the number of bits set per item
+	MaxBitsPerItem = 128 // bounds the size of filters, whatever the false positive rate
+)
+
+// BlockSizes are the supported block sizes, in bits
+var BlockSizes = []uint64{64, 256, 512}
+
+func validBlockSize(blockBits uint64) bool {
+	return slices.Contains(BlockSizes, blockBits)
+}
+
+// FalsePositiveRate returns the false positive rate of a blocked bloom filter of m bits
+// holding n items ("Cache-, Hash- and Space-Efficient Bloom Filters", Putze et al.):
+// the number of items in a block follows a Poisson law of mean n * blockBits / m, and
+// a block holding i items behaves like a bloom filter of blockBits bits with i items.
+func FalsePositiveRate(n, m, blockBits, k uint64) float64 {
+	if n == 0 {
+		return 0
+	}
+	lambda := float64(n) * float64(blockBits) / float64(m)
+	// sum the terms of the law within 12 standard deviations of its mean, the others are negligible
+	first := max(int(lambda-12*math.Sqrt(lambda)), 0)
+	last := int(lambda + 12*math.Sqrt(lambda) + 20)
+	kf := float64(k)
+	q := math.Pow(1-1/float64(blockBits), kf) // a bit of a block stays unset by an item with probability q
+	logLambda := math.Log(lambda)
+	logFactorial, _ := math.Lgamma(float64(first + 1))
+	logPoisson := float64(first)*logLambda - lambda - logFactorial // log of the probability of i items in a block
+	unset := math.Pow(q, float64(first))                           // a bit stays unset by i items
+	fpr := 0.0
+	for i := first; i <= last; i++ {
+		fpr += math.Exp(logPoisson) * math.Pow(1-unset, kf)
+		logPoisson += logLambda - math.Log(float64(i+1))
+		unset *= q
+	}
+	return min(fpr, 1)
+}
+
+// OptimalParameters returns the size in bits, the block size and the number of bits set per
+// item of the smallest filter of n items whose false positive rate is at most fpRate.
+// The number of blocks is rounded up to a power of two, the smallest block reaching fpRate
+// at that size is picked since it touches fewer words, with the k reaching fpRate with the
+// fewest bits, the rounding only lowers the rate. When no block size reaches fpRate, the
+// filter has MaxBitsPerItem bits per item and the block size and k of the lowest rate.
+func OptimalParameters(n uint64, fpRate float64) (m, blockBits, k uint64) {
+	n = max(n, 1)
+	params := rateParams(fpRate)
+	for i, b := range BlockSizes {
+		if p := params[i]; !math.IsInf(p.bitsPerItem, 1) {
+			if size := roundedSize(n, p.bitsPerItem, b); m == 0 || size < m {
+				m, blockBits, k = size, b, p.k
+			}
+		}
+	}
+	if m != 0 {
+		return m, blockBits, k
+	}
+
+	best := math.Inf(1)
+	for i, b := range BlockSizes {
+		if p := params[i]; p.fpr < best {
+			best, blockBits, k = p.fpr, b, p.k
+		}
+	}
+	return roundedSize(n, MaxBitsPerItem, blockBits), blockBits, k
+}
+
+// OptimalParametersForBlockSize returns the size in bits and the number of bits set per item
+// of the smallest filter of n items made of blocks of blockBits bits whose false positive rate
+// is at most fpRate, the number of blocks is rounded up to a power of two.
+func OptimalParametersForBlockSize(n uint64, fpRate float64, blockBits uint64) (m, k uint64) {
+	var p blockParams
+	if i := slices.Index(BlockSizes, blockBits); i >= 0 {
+		p = rateParams(fpRate)[i]
+	} else {
+		p = searchBlockParams(blockBits, fpRate)
+	}
+	return roundedSize(max(n, 1), p.bitsPerItem, blockBits), p.k
+}
+
+// roundedSize returns the size in bits of a filter of n items with bitsPerItem bits per item,
+// at most MaxBitsPerItem, once its number of blocks of blockBits bits is rounded up to a power of two
+func roundedSize(n uint64, bitsPerItem float64, blockBits uint64) uint64 {
+	minimum := uint64(math.Ceil(min(bitsPerItem, MaxBitsPerItem) * float64(n)))
+	return uint64(filter.NextPowerOfTwo(uint32(max((minimum+blockBits-1)/blockBits, 1)))) * blockBits
+}
+
+// OptimalExactParameters returns the parameters of the smallest filter of n items whose
+// false positive rate is at most fpRate, when the number of blocks is not rounded up.
+func OptimalExactParameters(n uint64, fpRate float64) (m, blockBits, k uint64) {
+	n = max(n, 1)
+	params := rateParams(fpRate)
+	best := math.Inf(1)
+	for i, b := range BlockSizes {
+		// prefer the smaller block on ties, it touches fewer words
+		if p := params[i]; p.bitsPerItem < best {
+			best, blockBits, k = p.bitsPerItem, b, p.k
+		}
+	}
+	if math.IsInf(best, 1) {
+		return MaxBitsPerItem * n, BlockSizes[len(BlockSizes)-1], MaxK
+	}
+	return uint64(math.Ceil(best * float64(n))), blockBits, k
+}
+
+// blockParams are the parameters reaching a false positive rate with a block size
+type blockParams struct {
+	bitsPerItem float64 // the fewest bits per item reaching the rate, +Inf when MaxBitsPerItem do not
+	k           uint64  // the bits set per item with bitsPerItem bits, or of the lowest rate with MaxBitsPerItem
+	fpr         float64 // the lowest rate with MaxBitsPerItem bits per item
+}
+
+// maxCachedRates bounds the cache of rateParams, programs use a few false positive rates,
+// the cache is emptied when a program uses more
+const maxCachedRates = 64
+
+var paramsCache struct {
+	sync.Mutex
+	rates map[float64][]blockParams
+}
+
+// rateParams returns the parameters reaching fpRate with every block size, in the order of
+// BlockSizes. They are searched once per rate, each search sums a few hundred Poisson laws.
+func rateParams(fpRate float64) []blockParams {
+	paramsCache.Lock()
+	params, ok := paramsCache.rates[fpRate]
+	paramsCache.Unlock()
+	if ok {
+		return params
+	}
+
+	params = make([]blockParams, len(BlockSizes))
+	for i, b := range BlockSizes {
+		params[i] = searchBlockParams(b, fpRate)
+	}
+	paramsCache.Lock()
+	if len(paramsCache.rates) >= maxCachedRates || paramsCache.rates == nil {
+		paramsCache.rates = make(map[float64][]blockParams)
+	}
+	paramsCache.rates[fpRate] = params
+	paramsCache.Unlock()
+	return params
+}
+
+// searchBlockParams searches the smallest bits per item reaching fpRate with blocks of
+// blockBits bits, the best k is taken at every step, the search only depends on their ratio
+func searchBlockParams(blockBits uint64, fpRate float64) blockParams {
+	const n = 1 << 20
+	lowestRate := func(bitsPerItem float64) (uint64, float64) {
+		return lowestRateK(n, uint64(bitsPerItem*n), blockBits)
+	}
+	k, fpr := lowestRate(MaxBitsPerItem)
+	if fpr > fpRate {
+		return blockParams{bitsPerItem: math.Inf(1), k: k, fpr: fpr}
+	}
+	p := blockParams{bitsPerItem: MaxBitsPerItem, k: k, fpr: fpr}
+	// a blocked filter needs more bits than a standard one, -ln(fpRate) / ln(2)^2 bits per item,
+	// the search starts above it, where the Poisson laws of small blocks have fewer terms
+	low := max(-math.Log(fpRate)/(math.Ln2*math.Ln2), 0.5)
+	if high := 2*low + 4; high < MaxBitsPerItem {
+		if k, rate := lowestRate(high); rate <= fpRate {
+			p.bitsPerItem, p.k = high, k
+		}
+	}
+	for p.bitsPerItem-low > 0.01 {
+		mid := (low + p.bitsPerItem) / 2
+		if k, rate := lowestRate(mid); rate > fpRate {
+			low = mid
+		} else {
+			p.bitsPerItem, p.k = mid, k
+		}
+	}
+	return p
+}
+
+// lowestRateK returns the number of bits set per item giving the lowest false positive
+// rate to a filter of m bits made of blocks of blockBits bits holding n items, and that rate.
+// The rate is unimodal in k, it is walked down from the best k of a standard bloom filter.
+func lowestRateK(n, m, blockBits uint64) (k uint64, fpr float64) {
+	k = uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	k = min(max(k, 1), MaxK)
+	fpr = FalsePositiveRate(n, m, blockBits, k)
+	for step := -1; step <= 1; step += 2 {
+		for kk := int(k) + step; kk >= 1 && kk <= MaxK; kk += step {
+			rate := FalsePositiveRate(n, m, blockBits, uint64(kk))
+			if rate >= fpr {
+				break
+			}
+			k, fpr = uint64(kk), rate
+		}
+	}
+	return k, fpr
+}
@@ -0,0 +1,121 @@
+package blockedbloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/rag-nar1/Filters/filter"
+)
+
+// parameters of the Apache Parquet split block Bloom filter
+const (
+	SBBFWordsPerBlock = 8                     // 32 bits words per block
+	SBBFBlockBytes    = SBBFWordsPerBlock * 4 // 256 bits per block
+	SBBFMinBytes      = SBBFBlockBytes        // parquet writers never write a smaller bitset
+	SBBFMaxBytes      = 128 * 1024 * 1024     // nor a larger one
+)
+
+// salts of the Parquet specification, word i of a block gets the bit selected by the
+// 5 most significant bits of x * SBBFSalts[i]
+var SBBFSalts = [SBBFWordsPerBlock]uint32{
+	0x47b6137b, 0x44974d91, 0x8824ad5b, 0xa2b7289d,
+	0x705495c7, 0x2df1424b, 0x9efc4947, 0x5c6bfb31,
+}
+
+var ErrInvalidBitset = errors.New("blockedbloom: bitset size is not a positive multiple of 32 bytes")
+
+// SplitBlockFilter is the split block Bloom filter mode of the Apache Parquet format:
+// the most significant 32 bits of the xxh64 hash of a value select a block of 256 bits,
+// and the least significant 32 bits set exactly one bit in each of its 8 words.
+// Its bitset is the one stored in the bloom filter pages of Parquet files.
+type SplitBlockFilter struct {
+	Blocks [][SBBFWordsPerBlock]uint32
+}
+
+// NewSplitBlockFilter sizes the filter as parquet writers do for n distinct values,
+// the bitset is rounded up to a power of two bytes in [SBBFMinBytes, SBBFMaxBytes].
+func NewSplitBlockFilter(n uint64, fpRate float64) *SplitBlockFilter {
+	// fpRate = (1 - exp(-8n/m))^8
+	bits := -8 * float64(n) / math.Log(1-math.Pow(fpRate, 1.0/8))
+	numBytes := uint32(min(math.Ceil(bits/8), SBBFMaxBytes))
+	numBytes = min(max(filter.NextPowerOfTwo(numBytes), SBBFMinBytes), SBBFMaxBytes)
+	return NewSplitBlockFilterWithBytes(numBytes)
+}
+
+// NewSplitBlockFilterWithBytes returns an empty filter of numBytes rounded up to a whole block.
+func NewSplitBlockFilterWithBytes(numBytes uint32) *SplitBlockFilter {
+	blocks := max((numBytes+SBBFBlockBytes-1)/SBBFBlockBytes, 1)
+	return &SplitBlockFilter{Blocks: make([][SBBFWordsPerBlock]uint32, blocks)}
+}
+
+// NewSplitBlockFilterFromBitset imports the bitset of a Parquet bloom filter page,
+// the bitset is copied.
+func NewSplitBlockFilterFromBitset(bitset []byte) (*SplitBlockFilter, error) {
+	if len(bitset) == 0 || len(bitset)%SBBFBlockBytes != 0 {
+		return nil, ErrInvalidBitset
+	}
+	sbf := &SplitBlockFilter{Blocks: make([][SBBFWordsPerBlock]uint32, len(bitset)/SBBFBlockBytes)}
+	for i := range sbf.Blocks {
+		for j := range sbf.Blocks[i] {
+			sbf.Blocks[i][j] = binary.LittleEndian.Uint32(bitset[(i*SBBFWordsPerBlock+j)*4:])
+		}
+	}
+	return sbf, nil
+}
+
+// Bitset exports the filter as Parquet stores it: the words of every block in little endian order.
+func (sbf *SplitBlockFilter) Bitset() []byte {
+	bitset := make([]byte, len(sbf.Blocks)*SBBFBlockBytes)
+	for i := range sbf.Blocks {
+		for j, word := range sbf.Blocks[i] {
+			binary.LittleEndian.PutUint32(bitset[(i*SBBFWordsPerBlock+j)*4:], word)
+		}
+	}
+	return bitset
+}
+
+// Insert adds data, which must be the plain encoding of the value as Parquet hashes it:
+// the little endian bytes of numbers and the bytes without length prefix of byte arrays.
+func (sbf *SplitBlockFilter) Insert(data []byte) {
+	sbf.InsertHash(xxhash.Sum64(data))
+}
+
+func (sbf *SplitBlockFilter) Exist(data []byte) bool {
+	return sbf.ExistHash(xxhash.Sum64(data))
+}
+
+// InsertHash adds a value from its xxh64 hash with seed 0.
+func (sbf *SplitBlockFilter) InsertHash(hash uint64) {
+	block := &sbf.Blocks[sbf.blockIndex(hash)]
+	for i, salt := range SBBFSalts {
+		block[i] |= 1 << ((uint32(hash) * salt) >> 27)
+	}
+}
+
+func (sbf *SplitBlockFilter) ExistHash(hash uint64) bool {
+	block := &sbf.Blocks[sbf.blockIndex(hash)]
+	for i, salt := range SBBFSalts {
+		if block[i]&(1<<((uint32(hash)*salt)>>27)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// AppendHash appends the indexes in the whole bitset of the 8 bits of data to dst,
+// reusing dst avoids any allocation
+func (sbf *SplitBlockFilter) AppendHash(dst []int, data []byte) []int {
+	hash := xxhash.Sum64(data)
+	block := sbf.blockIndex(hash)
+	for i, salt := range SBBFSalts {
+		dst = append(dst, int(block)*SBBFBlockBytes*8+i*32+int((uint32(hash)*salt)>>27))
+	}
+	return dst
+}
+
+// blockIndex maps the most significant 32 bits of hash to [0, number of blocks) with a multiply and shift
+func (sbf *SplitBlockFilter) blockIndex(hash uint64) uint64 {
+	return ((hash >> 32) * uint64(len(sbf.Blocks))) >> 32
+}
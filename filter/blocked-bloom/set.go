@@ -0,0 +1,85 @@
+package blockedbloom
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrIncompatible = errors.New("blockedbloom: filters have different parameters")
+
+// Compatible returns an error wrapping ErrIncompatible unless bf and other have the same
+// blocks and number of bits per key, so that a key sets the same bits in both
+func (bf *BlockedBloomFilter) Compatible(other *BlockedBloomFilter) error {
+	switch {
+	case bf.BlockBits != other.BlockBits:
+		return fmt.Errorf("%w: blocks of %d and %d bits", ErrIncompatible, bf.BlockBits, other.BlockBits)
+	case bf.BlockCount != other.BlockCount:
+		return fmt.Errorf("%w: %d and %d blocks", ErrIncompatible, bf.BlockCount, other.BlockCount)
+	case bf.k != other.k:
+		return fmt.Errorf("%w: k %d and %d", ErrIncompatible, bf.k, other.k)
+	}
+	return nil
+}
+
+// Union adds the keys of other to bf, bf is then the filter built from the keys of both
+func (bf *BlockedBloomFilter) Union(other *BlockedBloomFilter) error {
+	if err := bf.Compatible(other); err != nil {
+		return err
+	}
+	for i, word := range other.BloomFilters {
+		bf.BloomFilters[i] |= word
+	}
+	return nil
+}
+
+// Union returns a new filter holding the keys of all the filters, which are left unchanged
+func Union(filters ...*BlockedBloomFilter) (*BlockedBloomFilter, error) {
+	if len(filters) == 0 {
+		return nil, errors.New("blockedbloom: union of no filters")
+	}
+	union := filters[0].Clone()
+	for _, f := range filters[1:] {
+		if err := union.Union(f); err != nil {
+			return nil, err
+		}
+	}
+	return union, nil
+}
+
+// Intersect keeps in bf the bits also set in other. Every key inserted in both filters is
+// still found, but bits set by different keys of each filter survive too, so the false
+// positive rate is higher than the one of a filter built from the common keys.
+func (bf *BlockedBloomFilter) Intersect(other *BlockedBloomFilter) error {
+	if err := bf.Compatible(other); err != nil {
+		return err
+	}
+	for i, word := range other.BloomFilters {
+		bf.BloomFilters[i] &= word
+	}
+	return nil
+}
+
+// Clone returns a copy of bf that shares nothing with it, its blocks are cache aligned too
+func (bf *BlockedBloomFilter) Clone() *BlockedBloomFilter {
+	clone := newBlockedBloomFilter(bf.BlockCount, bf.BlockBits, bf.k)
+	copy(clone.BloomFilters, bf.BloomFilters)
+	return clone
+}
+
+// Equal reports whether bf and other are compatible and have the same bits set
+func (bf *BlockedBloomFilter) Equal(other *BlockedBloomFilter) bool {
+	if bf.Compatible(other) != nil {
+		return false
+	}
+	for i, word := range bf.BloomFilters {
+		if other.BloomFilters[i] != word {
+			return false
+		}
+	}
+	return true
+}
+
+// Clear removes every key, the parameters are kept
+func (bf *BlockedBloomFilter) Clear() {
+	clear(bf.BloomFilters)
+}
@@ -0,0 +1,23 @@
+package bloom
+
+import (
+	"context"
+
+	"github.com/rag-nar1/Filters/filter"
+)
+
+// InsertParallel inserts keys with opts.WorkerCount() goroutines setting the bits of bf with
+// filter.AtomicOr, as ConcurrentBloomFilter does, so the build takes no memory besides bf.
+// The probes of a key span the whole filter, workers cannot own a range of words as blocked
+// bloom ones own blocks. When ctx is cancelled, bf holds an unspecified part of the keys.
+func (bf *BloomFilter) InsertParallel(ctx context.Context, keys filter.Keys, opts filter.BuildOptions) error {
+	insert := bf.Concurrent().InsertDigest
+	if opts.WorkerCount() == 1 {
+		insert = bf.InsertDigest // a single writer needs no atomics
+	}
+	return filter.ParallelBuild(ctx, keys, opts, nil, func(_ int, batch []filter.Digest) {
+		for _, d := range batch {
+			insert(d)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package bloom
+
+import (
+	"sync/atomic"
+
+	"github.com/rag-nar1/Filters/filter"
+	"github.com/zeebo/xxh3"
+)
+
+// ConcurrentBloomFilter is a BloomFilter safe for concurrent use without locks: Insert sets
+// bits with filter.AtomicOr and Exist reads words with atomic loads. A key is only guaranteed
+// to be found by the Exist calls starting after its Insert returned.
+// BloomFilter stays the faster choice when a single goroutine uses the filter.
+type ConcurrentBloomFilter struct {
+	bf *BloomFilter
+}
+
+func NewConcurrentBloomFilter(n uint64, fpRate float64) *ConcurrentBloomFilter {
+	return NewBloomFilter(n, fpRate).Concurrent()
+}
+
+func NewConcurrentBloomFilterExact(n uint64, fpRate float64) *ConcurrentBloomFilter {
+	return NewBloomFilterExact(n, fpRate).Concurrent()
+}
+
+// Concurrent returns a ConcurrentBloomFilter sharing the bits of bf, which must not be
+// modified directly afterwards
+func (bf *BloomFilter) Concurrent() *ConcurrentBloomFilter {
+	return &ConcurrentBloomFilter{bf: bf}
+}
+
+func (cbf *ConcurrentBloomFilter) Insert(data []byte) {
+	cbf.insertHash(xxh3.Hash(data))
+}
+
+func (cbf *ConcurrentBloomFilter) Exist(data []byte) bool {
+	return cbf.existHash(xxh3.Hash(data))
+}
+
+func (cbf *ConcurrentBloomFilter) InsertDigest(d filter.Digest) {
+	cbf.insertHash(d.Lo)
+}
+
+func (cbf *ConcurrentBloomFilter) ExistDigest(d filter.Digest) bool {
+	return cbf.existHash(d.Lo)
+}
+
+func (cbf *ConcurrentBloomFilter) InsertString(s string) {
+	cbf.insertHash(xxh3.HashString(s))
+}
+
+func (cbf *ConcurrentBloomFilter) ExistString(s string) bool {
+	return cbf.existHash(xxh3.HashString(s))
+}
+
+func (cbf *ConcurrentBloomFilter) InsertUint64(x uint64) {
+	cbf.InsertDigest(filter.NewDigestUint64(x))
+}
+
+func (cbf *ConcurrentBloomFilter) ExistUint64(x uint64) bool {
+	return cbf.ExistDigest(filter.NewDigestUint64(x))
+}
+
+func (cbf *ConcurrentBloomFilter) insertHash(hash uint64) {
+	bf := cbf.bf
+	probes := filter.NewProbes(hash, bf.Scheme)
+	for i := uint32(0); i < bf.K; i++ {
+		idx := filter.Reduce(probes.Next(), bf.M)
+		filter.AtomicOr(&bf.Bits[idx>>6], uint64(1)<<(idx&63))
+	}
+}
+
+func (cbf *ConcurrentBloomFilter) existHash(hash uint64) bool {
+	bf := cbf.bf
+	probes := filter.NewProbes(hash, bf.Scheme)
+	for i := uint32(0); i < bf.K; i++ {
+		idx := filter.Reduce(probes.Next(), bf.M)
+		if (atomic.LoadUint64(&bf.Bits[idx>>6])>>(idx&63))&1 == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot returns a copy of the filter, it holds every key inserted before the call and
+// possibly some of the keys inserted concurrently
+func (cbf *ConcurrentBloomFilter) Snapshot() *BloomFilter {
+	snapshot := *cbf.bf
+	snapshot.Bits = make([]uint64, len(cbf.bf.Bits))
+	for i := range snapshot.Bits {
+		snapshot.Bits[i] = atomic.LoadUint64(&cbf.bf.Bits[i])
+	}
+	return &snapshot
+}
+
+// Serialize serializes a Snapshot, Deserialize(data).Concurrent() restores the filter
+func (cbf *ConcurrentBloomFilter) Serialize() []byte {
+	return cbf.Snapshot().Serialize()
+}
@@ -0,0 +1,79 @@
+package bloom
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// ErrSaturated is returned by the estimates of unions when every bit of the union is set,
+// its number of keys is then unbounded
+var ErrSaturated = errors.New("bloom: every bit is set, the number of keys cannot be estimated")
+
+// EstimatedCount estimates the number of distinct keys inserted from the number of bits set
+// (Swamidass and Baldi, "Mathematical correction for fingerprint similarity measures"):
+// n = -(M / K) * ln(1 - X / M) for X bits set. It is +Inf once every bit is set.
+func (bf *BloomFilter) EstimatedCount() float64 {
+	set := 0
+	for _, word := range bf.Bits {
+		set += bits.OnesCount64(word)
+	}
+	return bf.estimate(set)
+}
+
+func (bf *BloomFilter) estimate(set int) float64 {
+	if set >= int(bf.M) {
+		return math.Inf(1) // also for the bits of the last word past M, which Log1p would turn to NaN
+	}
+	m := float64(bf.M)
+	return -m / float64(bf.K) * math.Log1p(-float64(set)/m)
+}
+
+// CurrentFPR returns the false positive rate of the filter as filled: a key absent from the
+// filter is found when its K bits are set, each with probability X / M.
+func (bf *BloomFilter) CurrentFPR() float64 {
+	set := 0
+	for _, word := range bf.Bits {
+		set += bits.OnesCount64(word)
+	}
+	return math.Pow(float64(set)/float64(bf.M), float64(bf.K))
+}
+
+// EstimatedUnionCount estimates the number of distinct keys inserted in bf or other, the
+// EstimatedCount of their Union. It returns ErrSaturated instead of +Inf, as do
+// EstimatedIntersectionCount and Jaccard, whose estimates would be NaN.
+func (bf *BloomFilter) EstimatedUnionCount(other *BloomFilter) (float64, error) {
+	if err := bf.Compatible(other); err != nil {
+		return 0, err
+	}
+	set := 0
+	for i, word := range bf.Bits {
+		set += bits.OnesCount64(word | other.Bits[i])
+	}
+	union := bf.estimate(set)
+	if math.IsInf(union, 1) {
+		return 0, ErrSaturated
+	}
+	return union, nil
+}
+
+// EstimatedIntersectionCount estimates the number of distinct keys inserted in both bf and
+// other by inclusion-exclusion: |A| + |B| - |A ∪ B|, which is never negative
+func (bf *BloomFilter) EstimatedIntersectionCount(other *BloomFilter) (float64, error) {
+	union, err := bf.EstimatedUnionCount(other)
+	if err != nil {
+		return 0, err
+	}
+	return max(bf.EstimatedCount()+other.EstimatedCount()-union, 0), nil
+}
+
+// Jaccard estimates the Jaccard similarity of the keys of bf and other, |A ∩ B| / |A ∪ B|,
+// it is 0 for two empty filters
+func (bf *BloomFilter) Jaccard(other *BloomFilter) (float64, error) {
+	union, err := bf.EstimatedUnionCount(other)
+	if err != nil || union == 0 {
+		return 0, err
+	}
+	intersection, _ := bf.EstimatedIntersectionCount(other)
+	return min(intersection/union, 1), nil
+}
@@ -10,13 +10,18 @@ import (
 )
 
 type BloomFilter struct {
-	M    uint32 // size of bit-array
-	K    uint32 // number of hash-functions
-	Seed uint64
+	M      uint32 // size of bit-array
+	K      uint32 // number of hash-functions
+	Seed   uint64
+	Scheme filter.HashScheme // how the K indexes are derived from the hash
 
 	Bits []uint64 // the filter actual storage
 }
 
+// NewBloomFilter returns a filter of n keys at the false positive rate fpRate. Its Seed is drawn
+// at random, keys are not hashed with it but it tells filters built independently apart, which
+// Compatible rejects: filters meant to be combined are Clones of a common one, or are given its
+// Seed, e.g. other.Seed = bf.Seed.
 func NewBloomFilter(n uint64, fpRate float64) *BloomFilter {
 	// m = ceil((n * log(p)) / log(1 / pow(2, log(2))));
 	// k = round((m / n) * log(2));
@@ -24,34 +29,86 @@ func NewBloomFilter(n uint64, fpRate float64) *BloomFilter {
 	k := uint32(math.Round(float64(m) / float64(n) * math.Log(2)))
 	m = filter.NextPowerOfTwo(m)
 	return &BloomFilter{
-		M:    m,
-		K:    k,
-		Bits: make([]uint64, m>>6+1),
-		Seed: rand.Uint64(),
+		M:      m,
+		K:      k,
+		Bits:   make([]uint64, m>>6+1),
+		Seed:   rand.Uint64(),
+		Scheme: filter.EnhancedDoubleHashing,
+	}
+}
+
+// NewBloomFilterExact keeps the computed size instead of rounding it up to a power of two,
+// which can nearly double the memory, indexes are then reduced with a multiply and shift.
+func NewBloomFilterExact(n uint64, fpRate float64) *BloomFilter {
+	m := uint32(math.Ceil(float64(n) * math.Log(fpRate) / math.Log(1/math.Pow(2, math.Log(2)))))
+	m = max(m, 1)
+	k := uint32(math.Round(float64(m) / float64(n) * math.Log(2)))
+	return &BloomFilter{
+		M:      m,
+		K:      max(k, 1),
+		Bits:   make([]uint64, m>>6+1),
+		Seed:   rand.Uint64(),
+		Scheme: filter.EnhancedDoubleHashing,
 	}
 }
 
 func (bf *BloomFilter) Hash(data []byte) []int {
-	return filter.DoubleHash(xxh3.Hash(data), bf.M, bf.K)
+	return bf.AppendHash(make([]int, 0, bf.K), data)
+}
+
+// AppendHash appends the K bit indexes of data to dst, reusing dst avoids any allocation
+func (bf *BloomFilter) AppendHash(dst []int, data []byte) []int {
+	return filter.AppendProbes(dst, xxh3.Hash(data), bf.M, bf.K, bf.Scheme)
 }
 
 func (bf *BloomFilter) Insert(data []byte) {
-	hash := xxh3.Hash(data)
-	h1 := uint32(hash)
-	h2 := uint32(hash >> 32)
+	bf.insertHash(xxh3.Hash(data))
+}
+
+func (bf *BloomFilter) Exist(data []byte) bool {
+	return bf.existHash(xxh3.Hash(data))
+}
+
+// InsertDigest inserts the key of d, the same key as Insert(data) for d = filter.NewDigest(data)
+func (bf *BloomFilter) InsertDigest(d filter.Digest) {
+	bf.insertHash(d.Lo)
+}
+
+func (bf *BloomFilter) ExistDigest(d filter.Digest) bool {
+	return bf.existHash(d.Lo)
+}
+
+// InsertString inserts the bytes of s without copying them, as Insert([]byte(s))
+func (bf *BloomFilter) InsertString(s string) {
+	bf.insertHash(xxh3.HashString(s))
+}
+
+func (bf *BloomFilter) ExistString(s string) bool {
+	return bf.existHash(xxh3.HashString(s))
+}
+
+// InsertUint64 inserts an integer key hashed with filter.NewDigestUint64
+func (bf *BloomFilter) InsertUint64(x uint64) {
+	bf.InsertDigest(filter.NewDigestUint64(x))
+}
+
+func (bf *BloomFilter) ExistUint64(x uint64) bool {
+	return bf.ExistDigest(filter.NewDigestUint64(x))
+}
+
+func (bf *BloomFilter) insertHash(hash uint64) {
+	probes := filter.NewProbes(hash, bf.Scheme)
 	for i := uint32(0); i < bf.K; i++ {
-		idx := (h1 + i*h2) & (bf.M - 1)
+		idx := filter.Reduce(probes.Next(), bf.M)
 		pos := idx >> 6
 		bf.Bits[pos] |= uint64(1) << (idx & 63)
 	}
 }
 
-func (bf *BloomFilter) Exist(data []byte) bool {
-	hash := xxh3.Hash(data)
-	h1 := uint32(hash)
-	h2 := uint32(hash >> 32)
+func (bf *BloomFilter) existHash(hash uint64) bool {
+	probes := filter.NewProbes(hash, bf.Scheme)
 	for i := uint32(0); i < bf.K; i++ {
-		idx := (h1 + i*h2) & (bf.M - 1)
+		idx := filter.Reduce(probes.Next(), bf.M)
 		pos := idx >> 6
 		if (bf.Bits[pos]>>(idx&63))&1 == 0 {
 			return false
@@ -60,13 +117,56 @@ func (bf *BloomFilter) Exist(data []byte) bool {
 	return true
 }
 
+// InsertBatch inserts keys a window at a time: the window is hashed first so the
+// memory accesses of its keys do not wait for each other
+func (bf *BloomFilter) InsertBatch(keys [][]byte) {
+	var hashes [filter.BatchWindow]uint64
+	for start := 0; start < len(keys); start += filter.BatchWindow {
+		window := keys[start:min(start+filter.BatchWindow, len(keys))]
+		for i, key := range window {
+			hashes[i] = xxh3.Hash(key)
+		}
+		for i := range window {
+			probes := filter.NewProbes(hashes[i], bf.Scheme)
+			for j := uint32(0); j < bf.K; j++ {
+				idx := filter.Reduce(probes.Next(), bf.M)
+				bf.Bits[idx>>6] |= uint64(1) << (idx & 63)
+			}
+		}
+	}
+}
+
+// ExistBatch sets results[i] to Exist(keys[i]), results must be at least as long as keys.
+// The window is hashed first, then all the probes of its keys are checked without stopping
+// at the first unset bit, these independent loads overlap their cache misses.
+func (bf *BloomFilter) ExistBatch(keys [][]byte, results []bool) {
+	_ = results[:len(keys)]
+	var hashes [filter.BatchWindow]uint64
+	for start := 0; start < len(keys); start += filter.BatchWindow {
+		window := keys[start:min(start+filter.BatchWindow, len(keys))]
+		for i, key := range window {
+			hashes[i] = xxh3.Hash(key)
+		}
+		for i := range window {
+			probes := filter.NewProbes(hashes[i], bf.Scheme)
+			exist := uint64(1)
+			for j := uint32(0); j < bf.K; j++ {
+				idx := filter.Reduce(probes.Next(), bf.M)
+				exist &= bf.Bits[idx>>6] >> (idx & 63)
+			}
+			results[start+i] = exist&1 == 1
+		}
+	}
+}
+
 // Serialize the filter to a byte slice in the following format:
 // header|bits
-// header format: uint32(M)|uint32(K)|uint64(seed) => 4 + 4 + 8 = 16 bytes
+// header format: uint32(M)|uint32(K | Scheme << 24)|uint64(seed) => 4 + 4 + 8 = 16 bytes
+// filters serialized before the scheme was stored have a zero upper byte, i.e. filter.DoubleHashing
 func (bf *BloomFilter) Serialize() []byte {
 	buf := bytes.NewBuffer(make([]byte, 0, 16+len(bf.Bits)*8))
 	filter.SerializeUint(buf, uint64(bf.M), 4)
-	filter.SerializeUint(buf, uint64(bf.K), 4)
+	filter.SerializeUint(buf, uint64(bf.K)|uint64(bf.Scheme)<<24, 4)
 	filter.SerializeUint(buf, bf.Seed, 8)
 	for _, bit := range bf.Bits {
 		filter.SerializeUint(buf, bit, 8)
@@ -78,15 +178,18 @@ func Deserialize(data []byte) *BloomFilter {
 	buf := bytes.NewBuffer(data)
 	m := filter.DeserializeUint[uint32](buf, 4)
 	k := filter.DeserializeUint[uint32](buf, 4)
+	scheme := filter.HashScheme(k >> 24)
+	k &= 1<<24 - 1
 	seed := filter.DeserializeUint[uint64](buf, 8)
 	bits := make([]uint64, m/64+1)
 	for i := range bits {
 		bits[i] = filter.DeserializeUint[uint64](buf, 8)
 	}
 	return &BloomFilter{
-		M:    m,
-		K:    k,
-		Seed: seed,
-		Bits: bits,
+		M:      m,
+		K:      k,
+		Seed:   seed,
+		Scheme: scheme,
+		Bits:   bits,
 	}
 }
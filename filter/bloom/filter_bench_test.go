@@ -1,11 +1,15 @@
 package bloom_test
 
 import (
+	"context"
 	"fmt"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/rag-nar1/Filters/filter"
 	filterBloom "github.com/rag-nar1/Filters/filter/bloom"
 )
 
@@ -254,3 +258,138 @@ func BenchmarkBigDataOperations(b *testing.B) {
 	b.ReportMetric(float64(avgIterationTime.Milliseconds()), "avg_iteration_time_ms")
 	b.ReportMetric(float64(totalTime.Milliseconds()), "total_time_ms")
 }
+
+// BenchmarkExactSizing compares filters rounded up to a power of two with exactly sized ones,
+// whose indexes are reduced with a multiply and shift
+func BenchmarkExactSizing(b *testing.B) {
+	fpRate := 0.01
+	for _, n := range []int{100000, 1000000, 3000000} {
+		rounded := filterBloom.NewBloomFilter(uint64(n), fpRate)
+		exact := filterBloom.NewBloomFilterExact(uint64(n), fpRate)
+		testData := []byte("exact sizing data")
+
+		b.Run(fmt.Sprintf("n=%d/PowerOfTwo", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				rounded.Insert(testData)
+				rounded.Exist(testData)
+			}
+			b.ReportMetric(float64(rounded.M)/8/1024, "memory_KB")
+		})
+		b.Run(fmt.Sprintf("n=%d/Exact", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				exact.Insert(testData)
+				exact.Exist(testData)
+			}
+			b.ReportMetric(float64(exact.M)/8/1024, "memory_KB")
+			b.ReportMetric((1-float64(exact.M)/float64(rounded.M))*100, "memory_saved_%")
+		})
+	}
+}
+
+// BenchmarkBatch compares InsertBatch and ExistBatch with loops over Insert and Exist on a
+// filter much larger than the caches, where every probe is a cache miss
+func BenchmarkBatch(b *testing.B) {
+	n := 1 << 25
+	bf := filterBloom.NewBloomFilter(uint64(n), 0.01)
+	keys := make([][]byte, 1<<16)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("batch_key_%d", i))
+	}
+	results := make([]bool, len(keys))
+
+	b.Run("Insert", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bf.Insert(keys[i%len(keys)])
+		}
+	})
+	b.Run("InsertBatch", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i += len(keys) {
+			bf.InsertBatch(keys[:min(len(keys), b.N-i)])
+		}
+	})
+	b.Run("Exist", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			results[i%len(keys)] = bf.Exist(keys[i%len(keys)])
+		}
+	})
+	b.Run("ExistBatch", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i += len(keys) {
+			batch := keys[:min(len(keys), b.N-i)]
+			bf.ExistBatch(batch, results)
+		}
+	})
+}
+
+// BenchmarkConcurrent compares the lock free filter with a BloomFilter behind a mutex, and with
+// the plain BloomFilter when a single goroutine inserts
+func BenchmarkConcurrent(b *testing.B) {
+	n := uint64(1 << 24)
+	b.Run("Single/BloomFilter", func(b *testing.B) {
+		bf := filterBloom.NewBloomFilter(n, 0.01)
+		for i := 0; i < b.N; i++ {
+			bf.InsertUint64(uint64(i))
+		}
+	})
+	b.Run("Single/Concurrent", func(b *testing.B) {
+		cbf := filterBloom.NewConcurrentBloomFilter(n, 0.01)
+		for i := 0; i < b.N; i++ {
+			cbf.InsertUint64(uint64(i))
+		}
+	})
+	b.Run("Parallel/Mutex", func(b *testing.B) {
+		bf := filterBloom.NewBloomFilter(n, 0.01)
+		var mu sync.Mutex
+		var next atomic.Uint64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				key := next.Add(1)
+				mu.Lock()
+				bf.InsertUint64(key)
+				mu.Unlock()
+			}
+		})
+	})
+	b.Run("Parallel/Concurrent", func(b *testing.B) {
+		cbf := filterBloom.NewConcurrentBloomFilter(n, 0.01)
+		var next atomic.Uint64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				cbf.InsertUint64(next.Add(1))
+			}
+		})
+	})
+}
+
+// BenchmarkInsertParallel compares building a filter of n keys with Insert and with InsertParallel
+func BenchmarkInsertParallel(b *testing.B) {
+	n := 1 << 22
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("build_key_%d", i))
+	}
+	b.Run("Insert", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			bf := filterBloom.NewBloomFilter(uint64(n), 0.01)
+			for _, key := range keys {
+				bf.Insert(key)
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/key")
+	})
+	b.Run("InsertParallel", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			bf := filterBloom.NewBloomFilter(uint64(n), 0.01)
+			if err := bf.InsertParallel(context.Background(), filter.KeysFromSlice(keys), filter.BuildOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/key")
+	})
+}
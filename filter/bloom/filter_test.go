@@ -1,12 +1,16 @@
 package bloom_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/rag-nar1/Filters/filter"
 	filterBloom "github.com/rag-nar1/Filters/filter/bloom"
 )
 
@@ -282,3 +286,451 @@ func TestBenchmarkMetrics(t *testing.T) {
 		float64(bf.M) / float64(N),
 	)
 }
+
+func TestExactSizing(t *testing.T) {
+	n := 100000
+	fpRate := 0.01
+	bf := filterBloom.NewBloomFilterExact(uint64(n), fpRate)
+	rounded := filterBloom.NewBloomFilter(uint64(n), fpRate)
+	if bf.M&(bf.M-1) == 0 || bf.M >= rounded.M {
+		t.Fatalf("expected an exact size below %d, got %d", rounded.M, bf.M)
+	}
+
+	for i := 0; i < n; i++ {
+		bf.Insert([]byte(fmt.Sprintf("inserted_%d", i)))
+	}
+	for i := 0; i < n; i++ {
+		if !bf.Exist([]byte(fmt.Sprintf("inserted_%d", i))) {
+			t.Fatalf("false negative for inserted_%d", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if bf.Exist([]byte(fmt.Sprintf("not_inserted_%d", i))) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / float64(n); rate > fpRate*1.2 {
+		t.Errorf("false positive rate too high: %f (expected <= %f)", rate, fpRate)
+	}
+
+	// the reduction only depends on M, so a deserialized filter keeps using it
+	deserialized := filterBloom.Deserialize(bf.Serialize())
+	for i := 0; i < n; i++ {
+		if !deserialized.Exist([]byte(fmt.Sprintf("inserted_%d", i))) {
+			t.Fatalf("false negative for inserted_%d after deserialization", i)
+		}
+	}
+}
+
+// distinctProbes returns the average number of distinct indexes per key and the fraction
+// of keys whose K probes collapse on at most K/2 indexes
+func distinctProbes(bf *filterBloom.BloomFilter, keys int) (float64, float64) {
+	total, collapsed := 0, 0
+	buf := make([]int, 0, bf.K)
+	seen := make(map[int]struct{}, bf.K)
+	for i := 0; i < keys; i++ {
+		buf = bf.AppendHash(buf[:0], []byte(fmt.Sprintf("key_%d", i)))
+		clear(seen)
+		for _, idx := range buf {
+			seen[idx] = struct{}{}
+		}
+		total += len(seen)
+		if len(seen) <= int(bf.K)/2 {
+			collapsed++
+		}
+	}
+	return float64(total) / float64(keys), float64(collapsed) / float64(keys)
+}
+
+// with double hashing and a power of two M, every h2 that is a multiple of M/4 cycles over
+// at most 4 indexes, enhanced double hashing only loses a few probes to chance collisions
+func TestEnhancedDoubleHashing(t *testing.T) {
+	const keys = 200000
+	for _, m := range []uint32{1 << 10, 1 << 16} {
+		enhanced := &filterBloom.BloomFilter{M: m, K: 10, Scheme: filter.EnhancedDoubleHashing}
+		legacy := &filterBloom.BloomFilter{M: m, K: 10, Scheme: filter.DoubleHashing}
+
+		enhancedDistinct, enhancedCollapsed := distinctProbes(enhanced, keys)
+		legacyDistinct, legacyCollapsed := distinctProbes(legacy, keys)
+		t.Logf("M=%d: enhanced %.4f distinct probes, %.5f collapsed; double hashing %.4f distinct probes, %.5f collapsed",
+			m, enhancedDistinct, enhancedCollapsed, legacyDistinct, legacyCollapsed)
+
+		if legacyCollapsed == 0 || enhancedCollapsed >= legacyCollapsed {
+			t.Errorf("M=%d: enhanced double hashing collapses %.5f of the keys, double hashing %.5f", m, enhancedCollapsed, legacyCollapsed)
+		}
+	}
+
+	if bf := filterBloom.NewBloomFilter(1000, 0.01); bf.Scheme != filter.EnhancedDoubleHashing {
+		t.Errorf("expected new filters to use enhanced double hashing, got %d", bf.Scheme)
+	}
+}
+
+func TestSerializeScheme(t *testing.T) {
+	for _, scheme := range []filter.HashScheme{filter.DoubleHashing, filter.EnhancedDoubleHashing} {
+		bf := filterBloom.NewBloomFilter(1000, 0.01)
+		bf.Scheme = scheme
+		for i := 0; i < 1000; i++ {
+			bf.Insert([]byte(fmt.Sprintf("item_%d", i)))
+		}
+		serialized := bf.Serialize()
+		// filters serialized before the scheme was stored hold K alone
+		if scheme == filter.DoubleHashing && serialized[7] != 0 {
+			t.Fatalf("expected the upper byte of K to be 0, got %d", serialized[7])
+		}
+
+		deserialized := filterBloom.Deserialize(serialized)
+		if deserialized.Scheme != scheme || deserialized.K != bf.K {
+			t.Fatalf("expected scheme %d and K %d, got %d and %d", scheme, bf.K, deserialized.Scheme, deserialized.K)
+		}
+		for i := 0; i < 1000; i++ {
+			if !deserialized.Exist([]byte(fmt.Sprintf("item_%d", i))) {
+				t.Fatalf("scheme %d: false negative for item_%d after deserialization", scheme, i)
+			}
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	n := 10000
+	batch := filterBloom.NewBloomFilter(uint64(n), 0.01)
+	single := &filterBloom.BloomFilter{M: batch.M, K: batch.K, Scheme: batch.Scheme, Bits: make([]uint64, len(batch.Bits))}
+
+	keys := make([][]byte, 2*n+5) // not a multiple of the window
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key_%d", i))
+	}
+	batch.InsertBatch(keys[:n])
+	for _, key := range keys[:n] {
+		single.Insert(key)
+	}
+	for i := range batch.Bits {
+		if batch.Bits[i] != single.Bits[i] {
+			t.Fatalf("word %d differs between InsertBatch and Insert", i)
+		}
+	}
+
+	results := make([]bool, len(keys))
+	batch.ExistBatch(keys, results)
+	for i, key := range keys {
+		if results[i] != batch.Exist(key) {
+			t.Fatalf("ExistBatch and Exist disagree on %s", key)
+		}
+		if i < n && !results[i] {
+			t.Fatalf("false negative for %s", key)
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	const goroutines, perGoroutine = 16, 20000
+	bf := filterBloom.NewBloomFilter(goroutines*perGoroutine, 0.01)
+	sequential := &filterBloom.BloomFilter{M: bf.M, K: bf.K, Scheme: bf.Scheme, Bits: make([]uint64, len(bf.Bits))}
+	cbf := bf.Concurrent()
+
+	var wg sync.WaitGroup
+	errs := make(chan string, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				key := fmt.Sprintf("key_%d_%d", g, i)
+				cbf.InsertString(key)
+				// a key must be found as soon as its Insert returned, whatever the other writers do
+				if !cbf.ExistString(key) {
+					errs <- "false negative for " + key
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for g := 0; g < goroutines; g++ {
+		for i := 0; i < perGoroutine; i++ {
+			key := []byte(fmt.Sprintf("key_%d_%d", g, i))
+			if !cbf.Exist(key) {
+				t.Fatalf("false negative for %s", key)
+			}
+			sequential.Insert(key)
+		}
+	}
+	// no bit was lost by concurrent writers of the same word
+	snapshot := cbf.Snapshot()
+	for i := range sequential.Bits {
+		if snapshot.Bits[i] != sequential.Bits[i] {
+			t.Fatalf("word %d differs from sequential insertion", i)
+		}
+	}
+}
+
+func TestInsertParallel(t *testing.T) {
+	n := 100000
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key_%d", i))
+	}
+	sequential := filterBloom.NewBloomFilter(uint64(n), 0.01)
+	for _, key := range keys {
+		sequential.Insert(key)
+	}
+
+	// a single worker sets the bits directly, several ones atomically
+	for _, workers := range []int{1, 7} {
+		parallel := &filterBloom.BloomFilter{M: sequential.M, K: sequential.K, Scheme: sequential.Scheme, Bits: make([]uint64, len(sequential.Bits))}
+		var progress []uint64
+		opts := filter.BuildOptions{Workers: workers, ProgressEvery: 10000, Progress: func(keys uint64) { progress = append(progress, keys) }}
+		if err := parallel.InsertParallel(context.Background(), filter.KeysFromSlice(keys), opts); err != nil {
+			t.Fatal(err)
+		}
+		for i := range sequential.Bits {
+			if parallel.Bits[i] != sequential.Bits[i] {
+				t.Fatalf("workers=%d: word %d differs from sequential insertion", workers, i)
+			}
+		}
+		if len(progress) < 5 || progress[len(progress)-1] != uint64(n) {
+			t.Fatalf("workers=%d: unexpected progress %v", workers, progress)
+		}
+	}
+}
+
+func TestPaged(t *testing.T) {
+	const n = 100000
+	bf := filterBloom.NewBloomFilter(2*n, 0.01)
+	pbf := bf.Paged()
+	for i := 0; i < n; i++ {
+		bf.InsertUint64(uint64(i))
+		pbf.InsertUint64(uint64(i))
+	}
+	want := bf.Serialize()
+
+	// the snapshot is serialized while the filter keeps being written
+	snapshot := pbf.Snapshot()
+	serialized := make(chan []byte)
+	go func() { serialized <- snapshot.Serialize() }()
+	for i := n; i < 2*n; i++ {
+		pbf.InsertUint64(uint64(i))
+	}
+	if got := <-serialized; string(got) != string(want) {
+		t.Fatal("the snapshot does not serialize as the filter at the time it was taken")
+	}
+
+	restored := filterBloom.Deserialize(pbf.Serialize())
+	for i := 0; i < 2*n; i++ {
+		if !restored.ExistUint64(uint64(i)) || !pbf.ExistUint64(uint64(i)) {
+			t.Fatalf("key %d not found", i)
+		}
+	}
+	// but the last page, which only holds the spare word past M
+	if shared := pbf.SharedPages(); shared > 1 {
+		t.Fatalf("random insertions should have copied every page, %d shared", shared)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	const n, nodes = 30000, 3
+	template := filterBloom.NewBloomFilter(n, 0.01)
+	whole := template.Clone()
+	partials := make([]*filterBloom.BloomFilter, nodes)
+	for i := range partials {
+		partials[i] = template.Clone()
+	}
+	for i := 0; i < n; i++ {
+		whole.InsertUint64(uint64(i))
+		partials[i%nodes].InsertUint64(uint64(i))
+	}
+
+	union, err := filterBloom.Union(partials...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !union.Equal(whole) {
+		t.Fatal("the union of the partial filters differs from the filter of all the keys")
+	}
+	if partials[0].Equal(whole) {
+		t.Fatal("Union modified its first filter")
+	}
+	for _, p := range partials[1:] {
+		if err := partials[0].Union(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !partials[0].Equal(whole) {
+		t.Fatal("the in place union differs from the filter of all the keys")
+	}
+
+	// the keys of both filters survive an intersection
+	even := template.Clone()
+	for i := 0; i < n; i += 2 {
+		even.InsertUint64(uint64(i))
+	}
+	if err := even.Intersect(partials[1]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if i%2 == 0 && i%nodes == 1 && !even.ExistUint64(uint64(i)) {
+			t.Fatalf("key %d of both filters lost by the intersection", i)
+		}
+	}
+
+	whole.Clear()
+	if !whole.Equal(template) {
+		t.Fatal("Clear left bits set")
+	}
+
+	other := filterBloom.NewBloomFilter(n, 0.01)
+	if err := union.Union(other); !errors.Is(err, filterBloom.ErrIncompatible) {
+		t.Fatalf("filters of different seeds must not be merged, got %v", err)
+	}
+	if err := union.Intersect(filterBloom.NewBloomFilter(2*n, 0.01)); !errors.Is(err, filterBloom.ErrIncompatible) {
+		t.Fatalf("filters of different sizes must not be intersected, got %v", err)
+	}
+	if union.Equal(other) {
+		t.Fatal("incompatible filters cannot be equal")
+	}
+	other.Seed = union.Seed
+	if err := union.Union(other); err != nil {
+		t.Fatalf("filters given the same seed must be merged, got %v", err)
+	}
+}
+
+func TestEstimates(t *testing.T) {
+	const n = 50000
+	template := filterBloom.NewBloomFilter(n, 0.01)
+	a, b := template.Clone(), template.Clone()
+	// a holds [0, 30000), b holds [20000, 50000): 10000 common keys out of 50000
+	for i := 0; i < 30000; i++ {
+		a.InsertUint64(uint64(i))
+		b.InsertUint64(uint64(i + 20000))
+	}
+
+	within := func(name string, got, want, tolerance float64) {
+		t.Helper()
+		if math.Abs(got-want) > tolerance*want {
+			t.Errorf("%s: estimated %.4f, actual %.4f", name, got, want)
+		}
+	}
+	within("count", a.EstimatedCount(), 30000, 0.02)
+	union, err := a.EstimatedUnionCount(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	within("union", union, 50000, 0.02)
+	intersection, _ := a.EstimatedIntersectionCount(b)
+	within("intersection", intersection, 10000, 0.1)
+	jaccard, _ := a.Jaccard(b)
+	within("jaccard", jaccard, 0.2, 0.1)
+
+	// the current rate is the one measured on absent keys
+	falsePositives := 0
+	for i := n; i < 11*n; i++ {
+		if a.ExistUint64(uint64(i)) {
+			falsePositives++
+		}
+	}
+	within("false positive rate", a.CurrentFPR(), float64(falsePositives)/(10*n), 0.2)
+
+	if count := template.EstimatedCount(); count != 0 {
+		t.Errorf("empty filter estimated to hold %v keys", count)
+	}
+	if _, err := a.Jaccard(filterBloom.NewBloomFilter(n, 0.01)); !errors.Is(err, filterBloom.ErrIncompatible) {
+		t.Errorf("expected ErrIncompatible, got %v", err)
+	}
+}
+
+func TestEstimatesSaturated(t *testing.T) {
+	saturated := filterBloom.NewBloomFilter(100, 0.1)
+	for i := 0; i < 100000; i++ {
+		saturated.InsertUint64(uint64(i))
+	}
+	if count := saturated.EstimatedCount(); !math.IsInf(count, 1) {
+		t.Fatalf("expected +Inf keys in a saturated filter, got %v", count)
+	}
+	empty := saturated.Clone()
+	empty.Clear()
+	// the estimates of unions with a saturated filter are errors rather than +Inf or NaN
+	for _, pair := range [][2]*filterBloom.BloomFilter{{saturated, saturated}, {saturated, empty}, {empty, saturated}} {
+		if _, err := pair[0].EstimatedUnionCount(pair[1]); !errors.Is(err, filterBloom.ErrSaturated) {
+			t.Errorf("union: expected ErrSaturated, got %v", err)
+		}
+		if _, err := pair[0].EstimatedIntersectionCount(pair[1]); !errors.Is(err, filterBloom.ErrSaturated) {
+			t.Errorf("intersection: expected ErrSaturated, got %v", err)
+		}
+		if _, err := pair[0].Jaccard(pair[1]); !errors.Is(err, filterBloom.ErrSaturated) {
+			t.Errorf("jaccard: expected ErrSaturated, got %v", err)
+		}
+	}
+
+	// words set past M count as saturated too
+	for i := range empty.Bits {
+		empty.Bits[i] = ^uint64(0)
+	}
+	if count := empty.EstimatedCount(); !math.IsInf(count, 1) {
+		t.Fatalf("expected +Inf keys in a filter of every word set, got %v", count)
+	}
+}
+
+func TestFold(t *testing.T) {
+	const n = 10000
+	bf := filterBloom.NewBloomFilter(16*n, 0.001) // over-provisioned
+	for i := 0; i < n; i++ {
+		bf.InsertUint64(uint64(i))
+	}
+	m := bf.M
+
+	if err := bf.Fold(2); err != nil {
+		t.Fatal(err)
+	}
+	if bf.M != m/4 || len(bf.Bits) != int(m/4)>>6+1 {
+		t.Fatalf("expected M %d after 2 folds, got %d with %d words", m/4, bf.M, len(bf.Bits))
+	}
+	restored := filterBloom.Deserialize(bf.Serialize())
+	if restored.M != bf.M || !restored.Equal(bf) {
+		t.Fatal("the folded filter does not serialize with its new size")
+	}
+	for i := 0; i < n; i++ {
+		if !bf.ExistUint64(uint64(i)) || !restored.ExistUint64(uint64(i)) {
+			t.Fatalf("key %d lost by folding", i)
+		}
+	}
+
+	const target = 0.01
+	times, err := bf.ShrinkToFPR(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if times == 0 || bf.CurrentFPR() > target {
+		t.Fatalf("folded %d times to a false positive rate of %v, target %v", times, bf.CurrentFPR(), target)
+	}
+	further := bf.Clone()
+	if err := further.Fold(1); err == nil && further.CurrentFPR() <= target {
+		t.Fatal("ShrinkToFPR stopped before reaching its target")
+	}
+	for i := 0; i < n; i++ {
+		if !bf.ExistUint64(uint64(i)) {
+			t.Fatalf("key %d lost by ShrinkToFPR", i)
+		}
+	}
+	falsePositives := 0
+	for i := n; i < 101*n; i++ {
+		if bf.ExistUint64(uint64(i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / (100 * n); rate > 1.2*target {
+		t.Fatalf("false positive rate %v after ShrinkToFPR(%v)", rate, target)
+	}
+
+	if err := filterBloom.NewBloomFilterExact(n, 0.01).Fold(1); !errors.Is(err, filterBloom.ErrCannotFold) {
+		t.Fatalf("filters of exact size cannot be folded, got %v", err)
+	}
+	small := filterBloom.NewBloomFilter(10, 0.01)
+	if err := small.Fold(32); !errors.Is(err, filterBloom.ErrCannotFold) || small.M == 0 {
+		t.Fatalf("expected ErrCannotFold, got %v with M %d", err, small.M)
+	}
+}
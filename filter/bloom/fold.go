@@ -0,0 +1,70 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MinFoldedM is the smallest size a filter can be folded to, a single word
+const MinFoldedM = 64
+
+var ErrCannotFold = errors.New("bloom: filter cannot be folded")
+
+// Fold halves the size of the filter times times, keeping every key: with M a power of two a
+// key sets bit idx mod M, which is bit idx mod M/2 once the upper half of the bits is OR-ed into
+// the lower one. The false positive rate rises as the bits get denser, see CurrentFPR.
+// It returns an error wrapping ErrCannotFold, and leaves the filter unchanged, when M is not
+// a power of two, as the filters of NewBloomFilterExact, or would go below MinFoldedM.
+func (bf *BloomFilter) Fold(times int) error {
+	if err := bf.checkFold(times); err != nil {
+		return err
+	}
+	for i := 0; i < times; i++ {
+		bf.fold()
+	}
+	// release the upper halves
+	bf.Bits = append([]uint64(nil), bf.Bits...)
+	return nil
+}
+
+func (bf *BloomFilter) checkFold(times int) error {
+	switch {
+	case times < 0:
+		return fmt.Errorf("%w: negative number of folds %d", ErrCannotFold, times)
+	case bf.M&(bf.M-1) != 0:
+		return fmt.Errorf("%w: M %d is not a power of two", ErrCannotFold, bf.M)
+	case times > 0 && (times >= 32 || bf.M>>times < MinFoldedM):
+		return fmt.Errorf("%w: M %d cannot be halved %d times", ErrCannotFold, bf.M, times)
+	}
+	return nil
+}
+
+// fold halves M in place, the words past the new spare word are left to the caller
+func (bf *BloomFilter) fold() {
+	half := int(bf.M >> 7) // words of each half
+	for i := 0; i < half; i++ {
+		bf.Bits[i] |= bf.Bits[half+i]
+	}
+	bf.M >>= 1
+	bf.Bits = bf.Bits[:half+1]
+	bf.Bits[half] = 0
+}
+
+// ShrinkToFPR folds the filter as many times as its CurrentFPR stays at most target, and
+// returns the number of folds, none when the filter is already above target. It returns an
+// error wrapping ErrCannotFold when M is not a power of two.
+func (bf *BloomFilter) ShrinkToFPR(target float64) (int, error) {
+	if err := bf.checkFold(0); err != nil {
+		return 0, err
+	}
+	times := 0
+	folded := bf.Clone()
+	for folded.M>>1 >= MinFoldedM {
+		folded.fold()
+		if folded.CurrentFPR() > target {
+			break
+		}
+		times++
+	}
+	return times, bf.Fold(times)
+}
@@ -0,0 +1,133 @@
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/rag-nar1/Filters/filter"
+	"github.com/zeebo/xxh3"
+)
+
+// PagedBloomFilter is a BloomFilter storing its bits in filter.Pages, so that Snapshot takes
+// constant time and the writes following it copy only the pages they touch. A snapshot never
+// changes, it can be serialized by another goroutine while the filter keeps being written.
+// Like BloomFilter, it is not safe for concurrent writes.
+type PagedBloomFilter struct {
+	bf   *BloomFilter // parameters of the filter, its Bits are not used
+	bits *filter.Pages[uint64]
+}
+
+func NewPagedBloomFilter(n uint64, fpRate float64) *PagedBloomFilter {
+	return NewBloomFilter(n, fpRate).Paged()
+}
+
+func NewPagedBloomFilterExact(n uint64, fpRate float64) *PagedBloomFilter {
+	return NewBloomFilterExact(n, fpRate).Paged()
+}
+
+// Paged returns a PagedBloomFilter holding a copy of the bits of bf
+func (bf *BloomFilter) Paged() *PagedBloomFilter {
+	params := *bf
+	params.Bits = nil
+	return &PagedBloomFilter{bf: &params, bits: filter.PagesFromSlice(bf.Bits)}
+}
+
+func (pbf *PagedBloomFilter) Insert(data []byte) {
+	pbf.insertHash(xxh3.Hash(data))
+}
+
+func (pbf *PagedBloomFilter) Exist(data []byte) bool {
+	return pbf.existHash(xxh3.Hash(data))
+}
+
+func (pbf *PagedBloomFilter) InsertDigest(d filter.Digest) {
+	pbf.insertHash(d.Lo)
+}
+
+func (pbf *PagedBloomFilter) ExistDigest(d filter.Digest) bool {
+	return pbf.existHash(d.Lo)
+}
+
+func (pbf *PagedBloomFilter) InsertString(s string) {
+	pbf.insertHash(xxh3.HashString(s))
+}
+
+func (pbf *PagedBloomFilter) ExistString(s string) bool {
+	return pbf.existHash(xxh3.HashString(s))
+}
+
+func (pbf *PagedBloomFilter) InsertUint64(x uint64) {
+	pbf.InsertDigest(filter.NewDigestUint64(x))
+}
+
+func (pbf *PagedBloomFilter) ExistUint64(x uint64) bool {
+	return pbf.ExistDigest(filter.NewDigestUint64(x))
+}
+
+func (pbf *PagedBloomFilter) insertHash(hash uint64) {
+	bf := pbf.bf
+	probes := filter.NewProbes(hash, bf.Scheme)
+	for i := uint32(0); i < bf.K; i++ {
+		idx := filter.Reduce(probes.Next(), bf.M)
+		pbf.bits.Or(int(idx>>6), uint64(1)<<(idx&63))
+	}
+}
+
+func (pbf *PagedBloomFilter) existHash(hash uint64) bool {
+	bf := pbf.bf
+	probes := filter.NewProbes(hash, bf.Scheme)
+	for i := uint32(0); i < bf.K; i++ {
+		idx := filter.Reduce(probes.Next(), bf.M)
+		if (pbf.bits.Get(int(idx>>6))>>(idx&63))&1 == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot returns a copy of the filter in constant time, the pages of bits are shared until
+// either filter writes them
+func (pbf *PagedBloomFilter) Snapshot() *PagedBloomFilter {
+	return &PagedBloomFilter{bf: pbf.bf, bits: pbf.bits.Snapshot()}
+}
+
+// SharedPages returns the number of pages of bits still shared with a snapshot
+func (pbf *PagedBloomFilter) SharedPages() int {
+	return pbf.bits.SharedPages()
+}
+
+// WriteTo writes the filter in the format of BloomFilter.Serialize a page at a time, without
+// holding the whole serialized filter in memory
+func (pbf *PagedBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	bf := pbf.bf
+	header := bytes.NewBuffer(make([]byte, 0, 16))
+	filter.SerializeUint(header, uint64(bf.M), 4)
+	filter.SerializeUint(header, uint64(bf.K)|uint64(bf.Scheme)<<24, 4)
+	filter.SerializeUint(header, bf.Seed, 8)
+	n, err := w.Write(header.Bytes())
+	written := int64(n)
+
+	buf := make([]byte, 0, filter.PageWords*8)
+	pbf.bits.Range(func(words []uint64) bool {
+		if err != nil {
+			return false
+		}
+		buf = buf[:0]
+		for _, word := range words {
+			buf = binary.LittleEndian.AppendUint64(buf, word)
+		}
+		n, err = w.Write(buf)
+		written += int64(n)
+		return err == nil
+	})
+	return written, err
+}
+
+// Serialize the filter in the format of BloomFilter.Serialize, Deserialize(data).Paged()
+// restores it
+func (pbf *PagedBloomFilter) Serialize() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 16+pbf.bits.Len()*8))
+	pbf.WriteTo(buf)
+	return buf.Bytes()
+}
@@ -0,0 +1,91 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrIncompatible = errors.New("bloom: filters have different parameters")
+
+// Compatible returns an error wrapping ErrIncompatible unless bf and other have the same size,
+// number of hash functions and hash scheme, so that a key sets the same bits in both, and the
+// same Seed. NewBloomFilter draws a random seed, filters meant to be combined should be Clones
+// of a common filter, deserialized from it, or given its Seed.
+func (bf *BloomFilter) Compatible(other *BloomFilter) error {
+	switch {
+	case bf.M != other.M:
+		return fmt.Errorf("%w: M %d and %d", ErrIncompatible, bf.M, other.M)
+	case bf.K != other.K:
+		return fmt.Errorf("%w: K %d and %d", ErrIncompatible, bf.K, other.K)
+	case bf.Scheme != other.Scheme:
+		return fmt.Errorf("%w: hash schemes %d and %d", ErrIncompatible, bf.Scheme, other.Scheme)
+	case bf.Seed != other.Seed:
+		return fmt.Errorf("%w: seeds %#x and %#x", ErrIncompatible, bf.Seed, other.Seed)
+	}
+	return nil
+}
+
+// Union adds the keys of other to bf, bf is then the filter built from the keys of both.
+// Filters of different Seeds are rejected even with the same size, see NewBloomFilter.
+func (bf *BloomFilter) Union(other *BloomFilter) error {
+	if err := bf.Compatible(other); err != nil {
+		return err
+	}
+	for i, word := range other.Bits {
+		bf.Bits[i] |= word
+	}
+	return nil
+}
+
+// Union returns a new filter holding the keys of all the filters, which are left unchanged.
+// They must share their Seed, see NewBloomFilter.
+func Union(filters ...*BloomFilter) (*BloomFilter, error) {
+	if len(filters) == 0 {
+		return nil, errors.New("bloom: union of no filters")
+	}
+	union := filters[0].Clone()
+	for _, f := range filters[1:] {
+		if err := union.Union(f); err != nil {
+			return nil, err
+		}
+	}
+	return union, nil
+}
+
+// Intersect keeps in bf the bits also set in other. Every key inserted in both filters is
+// still found, but bits set by different keys of each filter survive too, so the false
+// positive rate is higher than the one of a filter built from the common keys.
+func (bf *BloomFilter) Intersect(other *BloomFilter) error {
+	if err := bf.Compatible(other); err != nil {
+		return err
+	}
+	for i, word := range other.Bits {
+		bf.Bits[i] &= word
+	}
+	return nil
+}
+
+// Clone returns a copy of bf that shares nothing with it
+func (bf *BloomFilter) Clone() *BloomFilter {
+	clone := *bf
+	clone.Bits = append([]uint64(nil), bf.Bits...)
+	return &clone
+}
+
+// Equal reports whether bf and other are compatible and have the same bits set
+func (bf *BloomFilter) Equal(other *BloomFilter) bool {
+	if bf.Compatible(other) != nil {
+		return false
+	}
+	for i, word := range bf.Bits {
+		if other.Bits[i] != word {
+			return false
+		}
+	}
+	return true
+}
+
+// Clear removes every key, the parameters are kept
+func (bf *BloomFilter) Clear() {
+	clear(bf.Bits)
+}
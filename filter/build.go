@@ -0,0 +1,239 @@
+package filter
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// BuildBatchSize is the number of keys hashed, and of digests handed to process, at once by ParallelBuild
+const BuildBatchSize = 4096
+
+// Keys iterates over the keys of a bulk build, it has the shape of iter.Seq[[]byte]: it calls
+// yield with every key and stops as soon as yield returns false. Keys are copied before yield
+// returns, their buffer can be reused.
+type Keys func(yield func(key []byte) bool)
+
+func KeysFromSlice(keys [][]byte) Keys {
+	return func(yield func([]byte) bool) {
+		for _, key := range keys {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
+
+// KeysFromChannel iterates over the keys received from ch until it is closed
+func KeysFromChannel(ch <-chan []byte) Keys {
+	return func(yield func([]byte) bool) {
+		for key := range ch {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
+
+// keyBatch holds copies of up to BuildBatchSize keys in a single buffer, key i is
+// data[ends[i-1]:ends[i]]
+type keyBatch struct {
+	data []byte
+	ends []int
+}
+
+func (b *keyBatch) reset() {
+	b.data, b.ends = b.data[:0], b.ends[:0]
+}
+
+func (b *keyBatch) key(i int) []byte {
+	if i == 0 {
+		return b.data[:b.ends[0]]
+	}
+	return b.data[b.ends[i-1]:b.ends[i]]
+}
+
+type BuildOptions struct {
+	Workers       int               // goroutines inserting keys, GOMAXPROCS when 0
+	Progress      func(keys uint64) // called with the number of keys inserted so far, never concurrently
+	ProgressEvery uint64            // keys between two Progress calls, 1<<20 when 0
+}
+
+// WorkerCount returns the number of workers of a build
+func (opts BuildOptions) WorkerCount() int {
+	if opts.Workers > 0 {
+		return opts.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// ParallelBuild copies keys into batches on the calling goroutine and hashes them into digests
+// on opts.WorkerCount() goroutines, which hand them in batches to process. route picks the
+// worker of a digest, so a worker can own a part of the filter: the hashing goroutines then send
+// the digests to opts.WorkerCount() more goroutines, the owners calling process. nil lets every
+// hashing goroutine process its own digests. process never runs concurrently for a worker.
+// It returns ctx.Err() when ctx is cancelled before all the keys are processed, the batches
+// already handed out are then dropped.
+func ParallelBuild(ctx context.Context, keys Keys, opts BuildOptions, route func(d Digest) int,
+	process func(worker int, batch []Digest)) error {
+	workers := opts.WorkerCount()
+	progressEvery := opts.ProgressEvery
+	if progressEvery == 0 {
+		progressEvery = 1 << 20
+	}
+
+	var done atomic.Uint64
+	var progressMu sync.Mutex
+	reported := uint64(0)
+	report := func(force bool) {
+		if opts.Progress == nil {
+			return
+		}
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		if n := done.Load(); n > reported && (force || n-reported >= progressEvery) {
+			reported = n
+			opts.Progress(n)
+		}
+	}
+	run := func(w int, batch []Digest) {
+		if ctx.Err() == nil {
+			process(w, batch)
+			done.Add(uint64(len(batch)))
+			report(false)
+		}
+	}
+
+	keyPool := sync.Pool{New: func() any { return &keyBatch{ends: make([]int, 0, BuildBatchSize)} }}
+	digestPool := sync.Pool{New: func() any {
+		batch := make([]Digest, 0, BuildBatchSize)
+		return &batch
+	}}
+	// send hands a batch to a channel unless ctx is cancelled first
+	send := func(ch chan<- *[]Digest, batch *[]Digest) bool {
+		select {
+		case ch <- batch:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var owners sync.WaitGroup
+	var ownerChannels []chan *[]Digest
+	if route != nil {
+		ownerChannels = make([]chan *[]Digest, workers)
+		for w := range ownerChannels {
+			ownerChannels[w] = make(chan *[]Digest, 2)
+			owners.Add(1)
+			go func(w int) {
+				defer owners.Done()
+				for batch := range ownerChannels[w] {
+					run(w, *batch)
+					*batch = (*batch)[:0]
+					digestPool.Put(batch)
+				}
+			}(w)
+		}
+	}
+
+	hashChannel := make(chan *keyBatch, workers)
+	var hashers sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		hashers.Add(1)
+		go func(w int) {
+			defer hashers.Done()
+			var routed []*[]Digest // the digests waiting for each owner
+			if route != nil {
+				routed = make([]*[]Digest, workers)
+				for o := range routed {
+					routed[o] = digestPool.Get().(*[]Digest)
+				}
+			}
+			digests := digestPool.Get().(*[]Digest)
+			for keys := range hashChannel {
+				*digests = (*digests)[:0]
+				if ctx.Err() == nil {
+					for i := range keys.ends {
+						*digests = append(*digests, NewDigest(keys.key(i)))
+					}
+				}
+				keys.reset()
+				keyPool.Put(keys)
+				if route == nil {
+					run(w, *digests)
+					continue
+				}
+				for _, d := range *digests {
+					o := route(d)
+					*routed[o] = append(*routed[o], d)
+					if len(*routed[o]) < BuildBatchSize {
+						continue
+					}
+					if send(ownerChannels[o], routed[o]) {
+						routed[o] = digestPool.Get().(*[]Digest)
+					} else {
+						*routed[o] = (*routed[o])[:0]
+					}
+				}
+			}
+			*digests = (*digests)[:0]
+			digestPool.Put(digests)
+			for o, batch := range routed {
+				if len(*batch) > 0 && ctx.Err() == nil && send(ownerChannels[o], batch) {
+					continue
+				}
+				*batch = (*batch)[:0]
+				digestPool.Put(batch)
+			}
+		}(w)
+	}
+
+	batch := keyPool.Get().(*keyBatch)
+	keys(func(key []byte) bool {
+		batch.data = append(batch.data, key...)
+		batch.ends = append(batch.ends, len(batch.data))
+		if len(batch.ends) < BuildBatchSize {
+			return true
+		}
+		select {
+		case hashChannel <- batch:
+			batch = keyPool.Get().(*keyBatch)
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+	if len(batch.ends) > 0 && ctx.Err() == nil {
+		select {
+		case hashChannel <- batch:
+		case <-ctx.Done():
+		}
+	}
+	close(hashChannel)
+	hashers.Wait()
+	for _, ch := range ownerChannels {
+		close(ch)
+	}
+	owners.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	report(true)
+	return nil
+}
+
+// ParallelRange splits [0, n) in workers contiguous ranges and runs fn on each of them concurrently
+func ParallelRange(n, workers int, fn func(worker, start, end int)) {
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			fn(w, n*w/workers, n*(w+1)/workers)
+		}(w)
+	}
+	wg.Wait()
+}
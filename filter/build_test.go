@@ -0,0 +1,90 @@
+package filter_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter"
+)
+
+func TestParallelBuild(t *testing.T) {
+	const n = 100000
+	// every key is yielded from the same buffer, ParallelBuild copies them
+	keys := func(yield func([]byte) bool) {
+		buf := make([]byte, 3)
+		for i := uint64(0); i < n; i++ {
+			buf[0], buf[1], buf[2] = byte(i), byte(i>>8), byte(i>>16)
+			if !yield(buf[:1+i%3]) {
+				return
+			}
+		}
+	}
+	want := uint64(0)
+	keys(func(key []byte) bool {
+		want ^= filter.NewDigest(key).Hi
+		return true
+	})
+
+	const workers = 4
+	var processed [workers]atomic.Uint64
+	var hashes [workers]uint64 // xor of the digests of each worker, which never runs concurrently
+	var progress []uint64
+	opts := filter.BuildOptions{Workers: workers, ProgressEvery: 5000, Progress: func(keys uint64) { progress = append(progress, keys) }}
+	route := func(d filter.Digest) int { return int(d.Lo % workers) }
+	err := filter.ParallelBuild(context.Background(), keys, opts, route, func(w int, batch []filter.Digest) {
+		for _, d := range batch {
+			if int(d.Lo%workers) != w {
+				t.Errorf("digest routed to worker %d instead of %d", w, d.Lo%workers)
+			}
+			hashes[w] ^= d.Hi
+		}
+		processed[w].Add(uint64(len(batch)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, got := uint64(0), uint64(0)
+	for w := range processed {
+		total += processed[w].Load()
+		got ^= hashes[w]
+	}
+	if total != n {
+		t.Fatalf("expected %d keys processed, got %d", n, total)
+	}
+	if got != want {
+		t.Fatal("the processed digests are not those of the keys")
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] <= progress[i-1] {
+			t.Fatalf("progress is not increasing: %v", progress)
+		}
+	}
+	if progress[len(progress)-1] != n {
+		t.Fatalf("expected the last progress to be %d, got %v", n, progress)
+	}
+}
+
+func TestParallelBuildCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	yielded := 0
+	infinite := func(yield func([]byte) bool) {
+		for yield([]byte("key")) {
+			yielded++
+		}
+	}
+	opts := filter.BuildOptions{Workers: 3, ProgressEvery: 10000, Progress: func(keys uint64) {
+		if keys >= 50000 {
+			cancel()
+		}
+	}}
+	err := filter.ParallelBuild(ctx, infinite, opts, nil, func(int, []filter.Digest) {})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if yielded > 1000000 {
+		t.Fatalf("the keys were still consumed after the cancellation: %d", yielded)
+	}
+}
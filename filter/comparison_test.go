@@ -9,6 +9,7 @@ import (
 
 	"github.com/rag-nar1/Filters/filter/bloom"
 	"github.com/rag-nar1/Filters/filter/cuckoo"
+	"github.com/rag-nar1/Filters/filter/ribbon"
 )
 
 const (
@@ -139,6 +140,64 @@ func TestBloomVsCuckooComparison(t *testing.T) {
 	fmt.Printf("| Throughput (kops)     | %-17.2f | %-17.2f |\n", bloomThroughputKops, cuckooThroughputKops)
 }
 
+func TestBloomVsRibbonComparison(t *testing.T) {
+	insertedData := generateData(N)
+	nonInsertedData := generateData(2 * N)[N:]
+
+	// --- Benchmarking Bloom Filter ---
+	startTime := time.Now()
+	bloomFilter := bloom.NewBloomFilter(N, BloomFPRate)
+	for _, item := range insertedData {
+		bloomFilter.Insert(item)
+	}
+	bloomBuildTime := time.Since(startTime)
+
+	startTime = time.Now()
+	fpCount := 0
+	for _, item := range nonInsertedData {
+		if bloomFilter.Exist(item) {
+			fpCount++
+		}
+	}
+	bloomAvgLookupTime := time.Since(startTime) / N
+	bloomFPR := float64(fpCount) / float64(len(nonInsertedData))
+	bloomBitsPerKey := float64(bloomFilter.M) / N
+
+	// --- Benchmarking Ribbon Filter ---
+	startTime = time.Now()
+	ribbonFilter := ribbon.NewRibbonFilter(BloomFPRate)
+	if err := ribbonFilter.Populate(insertedData); err != nil {
+		t.Fatalf("failed to build ribbon filter: %v", err)
+	}
+	ribbonBuildTime := time.Since(startTime)
+
+	startTime = time.Now()
+	fpCount = 0
+	for _, item := range nonInsertedData {
+		if ribbonFilter.Contains(item) {
+			fpCount++
+		}
+	}
+	ribbonAvgLookupTime := time.Since(startTime) / N
+	ribbonFPR := float64(fpCount) / float64(len(nonInsertedData))
+	ribbonBitsPerKey := float64(len(ribbonFilter.Solution)*64) / N
+
+	for _, item := range insertedData {
+		if !ribbonFilter.Contains(item) {
+			t.Fatalf("ribbon filter false negative for %s", item)
+		}
+	}
+
+	fmt.Println("\n--- Bloom vs Ribbon Comparison Results ---")
+	fmt.Printf("Number of items (N): %d, target FPR: %.4f%%\n\n", N, BloomFPRate*100)
+	fmt.Println("| Metric                | Bloom Filter      | Ribbon Filter     |")
+	fmt.Println("|-----------------------|-------------------|-------------------|")
+	fmt.Printf("| Bits per key          | %-17.2f | %-17.2f |\n", bloomBitsPerKey, ribbonBitsPerKey)
+	fmt.Printf("| Build Time (ms)       | %-17d | %-17d |\n", bloomBuildTime.Milliseconds(), ribbonBuildTime.Milliseconds())
+	fmt.Printf("| Avg. Lookup Time (ns) | %-17d | %-17d |\n", bloomAvgLookupTime.Nanoseconds(), ribbonAvgLookupTime.Nanoseconds())
+	fmt.Printf("| FPR (%%)               | %-17.4f | %-17.4f |\n", bloomFPR*100, ribbonFPR*100)
+}
+
 func init() {
 	// Seed random number generator for deterministic results
 	rand.New(rand.NewSource(time.Now().UnixNano()))
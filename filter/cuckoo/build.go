@@ -0,0 +1,120 @@
+package cuckoo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+
+	"github.com/rag-nar1/Filters/filter"
+)
+
+const (
+	FillBatch  = 1 << 20 // number of entries a worker of InsertParallel sorts before filling its buckets
+	chunkShift = 12      // entries are sorted by chunks of 1 << chunkShift buckets, 16KB that stay in the caches
+)
+
+var (
+	ErrFull = errors.New("cuckoo: filter is full")
+	// ErrMetroHash is returned by InsertParallel on filters hashing keys with metro, which
+	// would only find the keys it inserts through their digests
+	ErrMetroHash = errors.New("cuckoo: filter hashes keys with metro")
+)
+
+// InsertParallel inserts keys with opts.WorkerCount() goroutines, each owning a contiguous range
+// of buckets: keys are routed to the worker of their first bucket, which sorts them with a
+// counting sort on the chunk of the bucket and fills the buckets in order. The keys overflowing
+// their first bucket are then inserted concurrently with their eviction paths, as
+// ConcurrentCuckooFilter does.
+// It returns an error wrapping ErrFull with the number of keys that did not fit, the other keys
+// are inserted. It returns ErrMetroHash without inserting any key on filters hashing keys with
+// metro. When ctx is cancelled, cf holds an unspecified part of the keys.
+func (cf *CuckooFilter) InsertParallel(ctx context.Context, keys filter.Keys, opts filter.BuildOptions) error {
+	if cf.MetroHash {
+		return ErrMetroHash
+	}
+	cf.syncFpHashes() // before the workers hash keys concurrently
+	workers := opts.WorkerCount()
+	entries := make([][]uint64, workers)   // bucket<<8 | fingerprint, waiting to be sorted
+	sorted := make([][]uint64, workers)    // entries sorted by chunk
+	overflows := make([][]uint64, workers) // entries whose first bucket is full
+	fill := func(w int) {
+		first := uint32(uint64(w) * uint64(cf.M) / uint64(workers))
+		sorted[w] = sortByChunk(entries[w], sorted[w][:0], first)
+		for _, entry := range sorted[w] {
+			if !cf.BucketInsert(byte(entry), uint32(entry>>8)) {
+				overflows[w] = append(overflows[w], entry)
+			}
+		}
+		entries[w] = entries[w][:0]
+	}
+
+	route := func(d filter.Digest) int {
+		h1, _ := cf.digestHash(d)
+		return int(uint64(h1) * uint64(workers) / uint64(cf.M))
+	}
+	err := filter.ParallelBuild(ctx, keys, opts, route, func(w int, batch []filter.Digest) {
+		if entries[w] == nil {
+			entries[w] = make([]uint64, 0, FillBatch)
+		}
+		for _, d := range batch {
+			h1, fingerprint := cf.digestHash(d)
+			entries[w] = append(entries[w], uint64(h1)<<8|uint64(fingerprint))
+		}
+		if len(entries[w]) >= FillBatch {
+			fill(w)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	filter.ParallelRange(workers, workers, func(w, _, _ int) { fill(w) })
+
+	ccf := cf.Concurrent()
+	var failed atomic.Uint64
+	filter.ParallelRange(workers, workers, func(w, _, _ int) {
+		for _, entry := range overflows[w] {
+			if ctx.Err() != nil {
+				return
+			}
+			if !ccf.insert(uint32(entry>>8), byte(entry)) {
+				failed.Add(1)
+			}
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("%w: %d keys not inserted", ErrFull, n)
+	}
+	return nil
+}
+
+// sortByChunk appends to dst the entries ordered by the chunk of their bucket, the buckets
+// of the entries are at least first
+func sortByChunk(entries, dst []uint64, first uint32) []uint64 {
+	if len(entries) == 0 {
+		return dst
+	}
+	chunk := func(entry uint64) int { return int((uint32(entry>>8) - first) >> chunkShift) }
+	last := 0
+	for _, entry := range entries {
+		last = max(last, chunk(entry))
+	}
+	offsets := make([]int, last+2)
+	for _, entry := range entries {
+		offsets[chunk(entry)+1]++
+	}
+	for i := 1; i < len(offsets); i++ {
+		offsets[i] += offsets[i-1]
+	}
+	dst = slices.Grow(dst, len(entries))[:len(entries)]
+	for _, entry := range entries {
+		c := chunk(entry)
+		dst[offsets[c]] = entry
+		offsets[c]++
+	}
+	return dst
+}
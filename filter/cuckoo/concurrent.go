@@ -0,0 +1,286 @@
+package cuckoo
+
+import (
+	"math/bits"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rag-nar1/Filters/filter"
+)
+
+const (
+	Stripes        = 1 << 12 // number of locks of a ConcurrentCuckooFilter, bucket h is guarded by stripe h mod Stripes
+	InsertAttempts = 16      // eviction paths tried by an insertion before it gives up
+)
+
+// stripe guards the buckets h with h mod Stripes equal to its index, version is odd while they
+// are being modified. Stripes are padded to a cache line so their writers do not contend.
+type stripe struct {
+	mu      sync.Mutex
+	version atomic.Uint64
+	_       [filter.CacheLineSize - 16]byte
+}
+
+// ConcurrentCuckooFilter is a CuckooFilter safe for concurrent use, following libcuckoo
+// ("Algorithmic Improvements for Fast Concurrent Cuckoo Hashing", Li et al.):
+//   - writers lock the stripes of the at most two buckets they modify, in index order
+//   - an insertion into two full buckets searches an eviction path without any lock, then
+//     moves its entries backwards from the empty slot, locking and validating each move,
+//     and retries with a new path when a concurrent writer invalidated it
+//   - lookups take no lock, they read both buckets between two reads of the versions of
+//     their stripes and retry when a writer modified them in between
+//
+// Unlike CuckooFilter.Insert, a failed insertion leaves every stored fingerprint in place.
+type ConcurrentCuckooFilter struct {
+	cf      *CuckooFilter
+	stripes []stripe
+}
+
+func NewConcurrentCuckooFilter(n uint64, loadFactor float64) *ConcurrentCuckooFilter {
+	return NewCuckooFilter(n, loadFactor).Concurrent()
+}
+
+func NewConcurrentCuckooFilterExact(n uint64, loadFactor float64) *ConcurrentCuckooFilter {
+	return NewCuckooFilterExact(n, loadFactor).Concurrent()
+}
+
+// Concurrent returns a ConcurrentCuckooFilter sharing the buckets of cf, which must not be
+// modified directly afterwards
+func (cf *CuckooFilter) Concurrent() *ConcurrentCuckooFilter {
+	cf.syncFpHashes()
+	return &ConcurrentCuckooFilter{cf: cf, stripes: make([]stripe, Stripes)}
+}
+
+func (ccf *ConcurrentCuckooFilter) Insert(data []byte) bool {
+	h1, fingerprint := ccf.cf.Hash(data)
+	return ccf.insert(h1, fingerprint)
+}
+
+func (ccf *ConcurrentCuckooFilter) Lookup(data []byte) bool {
+	h1, fingerprint := ccf.cf.Hash(data)
+	return ccf.lookup(h1, fingerprint)
+}
+
+func (ccf *ConcurrentCuckooFilter) Delete(data []byte) bool {
+	h1, fingerprint := ccf.cf.Hash(data)
+	return ccf.delete(h1, fingerprint)
+}
+
+func (ccf *ConcurrentCuckooFilter) InsertDigest(d filter.Digest) bool {
+	h1, fingerprint := ccf.cf.digestHash(d)
+	return ccf.insert(h1, fingerprint)
+}
+
+func (ccf *ConcurrentCuckooFilter) ExistDigest(d filter.Digest) bool {
+	h1, fingerprint := ccf.cf.digestHash(d)
+	return ccf.lookup(h1, fingerprint)
+}
+
+func (ccf *ConcurrentCuckooFilter) DeleteDigest(d filter.Digest) bool {
+	h1, fingerprint := ccf.cf.digestHash(d)
+	return ccf.delete(h1, fingerprint)
+}
+
+func (ccf *ConcurrentCuckooFilter) InsertString(s string) bool {
+	h1, fingerprint := ccf.cf.hashString(s)
+	return ccf.insert(h1, fingerprint)
+}
+
+func (ccf *ConcurrentCuckooFilter) ExistString(s string) bool {
+	h1, fingerprint := ccf.cf.hashString(s)
+	return ccf.lookup(h1, fingerprint)
+}
+
+func (ccf *ConcurrentCuckooFilter) DeleteString(s string) bool {
+	h1, fingerprint := ccf.cf.hashString(s)
+	return ccf.delete(h1, fingerprint)
+}
+
+func (ccf *ConcurrentCuckooFilter) InsertUint64(x uint64) bool {
+	return ccf.InsertDigest(filter.NewDigestUint64(x))
+}
+
+func (ccf *ConcurrentCuckooFilter) ExistUint64(x uint64) bool {
+	return ccf.ExistDigest(filter.NewDigestUint64(x))
+}
+
+func (ccf *ConcurrentCuckooFilter) DeleteUint64(x uint64) bool {
+	return ccf.DeleteDigest(filter.NewDigestUint64(x))
+}
+
+func (ccf *ConcurrentCuckooFilter) lookup(h1 uint32, fingerprint byte) bool {
+	cf := ccf.cf
+	h2 := cf.alternateIndex(h1, fingerprint)
+	s1, s2 := &ccf.stripes[h1%Stripes], &ccf.stripes[h2%Stripes]
+	for {
+		v1, v2 := s1.version.Load(), s2.version.Load()
+		b1, b2 := atomic.LoadUint32(&cf.Buckets[h1]), atomic.LoadUint32(&cf.Buckets[h2])
+		// a fingerprint seen in a bucket was stored there, only a miss can be caused by a
+		// move between the two buckets and needs the versions to be validated
+		if matches(b1, fingerprint)|matches(b2, fingerprint) != 0 {
+			return true
+		}
+		if (v1|v2)&1 == 0 && s1.version.Load() == v1 && s2.version.Load() == v2 {
+			return false
+		}
+		runtime.Gosched()
+	}
+}
+
+func (ccf *ConcurrentCuckooFilter) insert(h1 uint32, fingerprint byte) bool {
+	h2 := ccf.cf.alternateIndex(h1, fingerprint)
+	return ccf.tryInsert(fingerprint, h1, h2) || ccf.insertEvicting(fingerprint, h1, h2)
+}
+
+// insertEvicting frees a slot of bucket h1 or h2 with an eviction path before inserting, the
+// path buffer lives in this function so insertions into a free slot do not clear it
+func (ccf *ConcurrentCuckooFilter) insertEvicting(fingerprint byte, h1, h2 uint32) bool {
+	var path [MaxKicks]move
+	for attempt := 0; attempt < InsertAttempts; attempt++ {
+		n, found := ccf.searchPath(&path, RandomChoise(h1, h2))
+		if !found {
+			return false
+		}
+		// a failed move only means that a concurrent writer got in the way, try again
+		ccf.executePath(path[:n])
+		if ccf.tryInsert(fingerprint, h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// tryInsert stores fingerprint in an empty slot of bucket h1 or h2
+func (ccf *ConcurrentCuckooFilter) tryInsert(fingerprint byte, h1, h2 uint32) bool {
+	s1, s2 := ccf.lock(h1, h2)
+	defer ccf.unlock(s1, s2)
+	return ccf.bucketInsert(fingerprint, h1) || ccf.bucketInsert(fingerprint, h2)
+}
+
+// move is a step of an eviction path: the fingerprint at bit shift of bucket from goes to
+// its alternate bucket
+type move struct {
+	from        uint32
+	shift       uint32
+	fingerprint byte
+}
+
+// searchPath walks random evictions from bucket h, without locks nor writes, until it reaches
+// a bucket with an empty slot. It returns the number of moves of the path, whether one was found.
+func (ccf *ConcurrentCuckooFilter) searchPath(path *[MaxKicks]move, h uint32) (int, bool) {
+	cf := ccf.cf
+	for i := range path {
+		bucket := atomic.LoadUint32(&cf.Buckets[h])
+		if matches(bucket, FPNULL) != 0 {
+			return i, true
+		}
+		shift := uint32(rand.Intn(BucketSize)) * FpSize
+		fingerprint := byte(bucket >> shift)
+		path[i] = move{from: h, shift: shift, fingerprint: fingerprint}
+		h = cf.alternateIndex(h, fingerprint)
+	}
+	return 0, false
+}
+
+// executePath applies the moves of path from the last one, whose destination had an empty
+// slot, so every move fills the slot freed by the next one. It stops at the first move
+// invalidated by a concurrent writer, each applied move leaves a valid filter anyway.
+func (ccf *ConcurrentCuckooFilter) executePath(path []move) bool {
+	cf := ccf.cf
+	for i := len(path) - 1; i >= 0; i-- {
+		m := path[i]
+		to := cf.alternateIndex(m.from, m.fingerprint)
+		if to == m.from {
+			return false
+		}
+		s1, s2 := ccf.lock(m.from, to)
+		from := atomic.LoadUint32(&cf.Buckets[m.from])
+		valid := byte(from>>m.shift) == m.fingerprint && ccf.bucketInsert(m.fingerprint, to)
+		if valid {
+			atomic.StoreUint32(&cf.Buckets[m.from], from&^(0xff<<m.shift))
+		}
+		ccf.unlock(s1, s2)
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
+
+func (ccf *ConcurrentCuckooFilter) delete(h1 uint32, fingerprint byte) bool {
+	h2 := ccf.cf.alternateIndex(h1, fingerprint)
+	s1, s2 := ccf.lock(h1, h2)
+	defer ccf.unlock(s1, s2)
+	return ccf.bucketDelete(fingerprint, h1) || ccf.bucketDelete(fingerprint, h2)
+}
+
+// bucketInsert and bucketDelete are BucketInsert and bucketDelete for a writer holding the
+// stripe of h, with atomic accesses for the concurrent lookups
+func (ccf *ConcurrentCuckooFilter) bucketInsert(fingerprint byte, h uint32) bool {
+	bucket := atomic.LoadUint32(&ccf.cf.Buckets[h])
+	empty := matches(bucket, FPNULL)
+	if empty == 0 {
+		return false
+	}
+	atomic.StoreUint32(&ccf.cf.Buckets[h], bucket|uint32(fingerprint)<<(bits.TrailingZeros32(empty)&^7))
+	return true
+}
+
+func (ccf *ConcurrentCuckooFilter) bucketDelete(fingerprint byte, h uint32) bool {
+	bucket := atomic.LoadUint32(&ccf.cf.Buckets[h])
+	match := matches(bucket, fingerprint)
+	if match == 0 {
+		return false
+	}
+	atomic.StoreUint32(&ccf.cf.Buckets[h], bucket&^(0xff<<(bits.TrailingZeros32(match)&^7)))
+	return true
+}
+
+// lock locks the stripes of buckets h1 and h2 in index order, so writers never deadlock, and
+// makes their versions odd. s2 is nil when both buckets share a stripe.
+func (ccf *ConcurrentCuckooFilter) lock(h1, h2 uint32) (s1, s2 *stripe) {
+	i, j := h1%Stripes, h2%Stripes
+	if i > j {
+		i, j = j, i
+	}
+	s1 = &ccf.stripes[i]
+	s1.mu.Lock()
+	s1.version.Add(1)
+	if j != i {
+		s2 = &ccf.stripes[j]
+		s2.mu.Lock()
+		s2.version.Add(1)
+	}
+	return s1, s2
+}
+
+func (ccf *ConcurrentCuckooFilter) unlock(s1, s2 *stripe) {
+	if s2 != nil {
+		s2.version.Add(1)
+		s2.mu.Unlock()
+	}
+	s1.version.Add(1)
+	s1.mu.Unlock()
+}
+
+// Snapshot returns a copy of the filter taken while every stripe is locked, it holds every key
+// inserted before the call and none is lost to a move happening during the copy
+func (ccf *ConcurrentCuckooFilter) Snapshot() *CuckooFilter {
+	for i := range ccf.stripes {
+		ccf.stripes[i].mu.Lock()
+	}
+	snapshot := *ccf.cf
+	snapshot.Buckets = make([]uint32, len(ccf.cf.Buckets))
+	copy(snapshot.Buckets, ccf.cf.Buckets)
+	for i := range ccf.stripes {
+		ccf.stripes[i].mu.Unlock()
+	}
+	return &snapshot
+}
+
+// Serialize serializes a Snapshot, Deserialize(data).Concurrent() restores the filter
+func (ccf *ConcurrentCuckooFilter) Serialize() []byte {
+	return ccf.Snapshot().Serialize()
+}
@@ -2,7 +2,9 @@ package cuckoo
 
 import (
 	"bytes"
+	"encoding/binary"
 	"math"
+	"math/bits"
 	"math/rand"
 
 	"github.com/dgryski/go-metro"
@@ -18,30 +20,80 @@ const (
 	FPNULL     = 0
 )
 
+// SWAR constants, a bucket is read as a uint32 holding one fingerprint per byte
+const (
+	lowBits  = 0x01010101
+	highBits = 0x80808080
+)
+
+// digestHashing flags, in the header byte following the seeds, the filters hashing keys through
+// a filter.Digest. Metro hashing filters keep the legacy header without it, the two are told apart
+// by the length of the data since the buckets take BucketSize bytes each.
+const digestHashing = 1
+
 type CuckooFilter struct {
-	M       uint32 // number of buckets
-	Buckets [][BucketSize]byte
-	Seed    uint64
-	FpSeed  uint64
+	M         uint32   // number of buckets
+	Buckets   []uint32 // the BucketSize fingerprints of bucket i are the bytes of Buckets[i], least significant first
+	Seed      uint64
+	FpSeed    uint64
+	MetroHash bool // keys are hashed with metro, as filters serialized before filter.Digest
+
+	fpHashes [1 << FpSize]uint32 // hash of every fingerprint reduced to [0, M), for AlternateIndex
+	fpM      uint32              // the M and FpSeed fpHashes was built from, it is rebuilt when
+	fpSeed   uint64              // they change, e.g. by a CuckooFilter literal or copied seeds
 }
 
 func NewCuckooFilter(n uint64, loadFactor float64) *CuckooFilter {
 	m := filter.NextPowerOfTwo(uint32(math.Ceil(float64(n) / float64(BucketSize) / loadFactor)))
 	m = max(m, 1)
-	return &CuckooFilter{
+	return newCuckooFilter(m, rand.Uint64(), rand.Uint64())
+}
+
+// NewCuckooFilterExact keeps the computed number of buckets instead of rounding it up to
+// a power of two, buckets are then selected with a multiply and shift and the alternate
+// bucket is (hash(fingerprint) - index) mod M instead of a xor.
+func NewCuckooFilterExact(n uint64, loadFactor float64) *CuckooFilter {
+	m := uint32(math.Ceil(float64(n) / float64(BucketSize) / loadFactor))
+	m = max(m, 2)
+	return newCuckooFilter(m, rand.Uint64(), rand.Uint64())
+}
+
+func newCuckooFilter(m uint32, seed, fpSeed uint64) *CuckooFilter {
+	cf := &CuckooFilter{
 		M:       m,
-		Buckets: make([][BucketSize]byte, m),
-		Seed:    rand.Uint64(),
-		FpSeed:  rand.Uint64(),
+		Buckets: make([]uint32, m),
+		Seed:    seed,
+		FpSeed:  fpSeed,
+	}
+	cf.buildFpHashes()
+	return cf
+}
+
+// syncFpHashes rebuilds fpHashes when M or FpSeed changed since it was built. Only the first
+// call after a change writes, filters shared between goroutines call it once beforehand.
+func (cf *CuckooFilter) syncFpHashes() {
+	if cf.fpM != cf.M || cf.fpSeed != cf.FpSeed {
+		cf.buildFpHashes()
+	}
+}
+
+func (cf *CuckooFilter) buildFpHashes() {
+	for fp := range cf.fpHashes {
+		cf.fpHashes[fp] = filter.Reduce(uint32(metro.Hash64([]byte{byte(fp)}, cf.FpSeed)>>32), cf.M)
 	}
+	cf.fpM, cf.fpSeed = cf.M, cf.FpSeed
 }
 
 func (cf *CuckooFilter) Insert(data []byte) bool {
 	h1, fingerprint := cf.Hash(data)
+	return cf.insert(h1, fingerprint)
+}
+
+func (cf *CuckooFilter) insert(h1 uint32, fingerprint byte) bool {
 	if cf.BucketInsert(fingerprint, h1) {
 		return true
 	}
-	h2 := cf.AlternateIndex(h1, fingerprint)
+	h2 := cf.alternateIndex(h1, fingerprint)
 	if cf.BucketInsert(fingerprint, h2) {
 		return true
 	}
@@ -49,88 +101,223 @@ func (cf *CuckooFilter) Insert(data []byte) bool {
 }
 
 func (cf *CuckooFilter) InsertFingerprint(fingerprint byte, h uint32, kickingIdx uint32) bool {
-	if kickingIdx > MaxKicks {
-		return false
-	}
+	cf.syncFpHashes()
+	for ; kickingIdx <= MaxKicks; kickingIdx++ {
+		if cf.BucketInsert(fingerprint, h) {
+			return true
+		}
 
-	if cf.BucketInsert(fingerprint, h) {
-		return true
+		// kick a random entry to avoid going through the same graph cycle
+		shift := uint32(rand.Intn(BucketSize)) * FpSize
+		kickedFingerprint := byte(cf.Buckets[h] >> shift)
+		cf.Buckets[h] = cf.Buckets[h]&^(0xff<<shift) | uint32(fingerprint)<<shift
+
+		fingerprint, h = kickedFingerprint, cf.alternateIndex(h, kickedFingerprint)
 	}
+	return false
+}
 
-	// kick a random bucket to avoid going through the same graph cycle
-	randomIndex := rand.Intn(BucketSize)
-	kickedFingerprint := cf.Buckets[h][randomIndex]
-	cf.Buckets[h][randomIndex] = fingerprint
+func (cf *CuckooFilter) Lookup(data []byte) bool {
+	h1, fingerprint := cf.Hash(data)
+	return cf.lookup(h1, fingerprint)
+}
 
-	return cf.InsertFingerprint(kickedFingerprint, cf.AlternateIndex(h, kickedFingerprint), kickingIdx+1)
+func (cf *CuckooFilter) lookup(h1 uint32, fingerprint byte) bool {
+	h2 := cf.alternateIndex(h1, fingerprint)
+	return matches(cf.Buckets[h1], fingerprint)|matches(cf.Buckets[h2], fingerprint) != 0
 }
 
-func (cf *CuckooFilter) Lookup(data []byte) bool {
+func (cf *CuckooFilter) Delete(data []byte) bool {
 	h1, fingerprint := cf.Hash(data)
+	return cf.delete(h1, fingerprint)
+}
 
-	for _, val := range cf.Buckets[h1] {
-		if val == fingerprint {
-			return true
-		}
-	}
+func (cf *CuckooFilter) delete(h1 uint32, fingerprint byte) bool {
+	return cf.bucketDelete(fingerprint, h1) || cf.bucketDelete(fingerprint, cf.alternateIndex(h1, fingerprint))
+}
 
-	h2 := cf.AlternateIndex(h1, fingerprint)
-	for _, val := range cf.Buckets[h2] {
-		if val == fingerprint {
-			return true
-		}
+func (cf *CuckooFilter) bucketDelete(fingerprint byte, h uint32) bool {
+	match := matches(cf.Buckets[h], fingerprint)
+	if match == 0 {
+		return false
 	}
+	cf.Buckets[h] &^= 0xff << (bits.TrailingZeros32(match) &^ 7)
+	return true
+}
 
-	return false
+// InsertDigest inserts the key of d, the digest is seeded with Seed so filters of different
+// seeds get independent hashes from it. Filters hashing keys with metro hash the digest
+// instead, Insert(data) and InsertDigest(filter.NewDigest(data)) are then different keys.
+func (cf *CuckooFilter) InsertDigest(d filter.Digest) bool {
+	h1, fingerprint := cf.digestHash(d)
+	return cf.insert(h1, fingerprint)
 }
 
-func (cf *CuckooFilter) Delete(data []byte) bool {
-	h1, fingerprint := cf.Hash(data)
+func (cf *CuckooFilter) ExistDigest(d filter.Digest) bool {
+	h1, fingerprint := cf.digestHash(d)
+	return cf.lookup(h1, fingerprint)
+}
 
-	for i, val := range cf.Buckets[h1] {
-		if val == fingerprint {
-			cf.Buckets[h1][i] = FPNULL
-			return true
-		}
-	}
+func (cf *CuckooFilter) DeleteDigest(d filter.Digest) bool {
+	h1, fingerprint := cf.digestHash(d)
+	return cf.delete(h1, fingerprint)
+}
 
-	h2 := cf.AlternateIndex(h1, fingerprint)
-	for i, val := range cf.Buckets[h2] {
-		if val == fingerprint {
-			cf.Buckets[h2][i] = FPNULL
-			return true
-		}
-	}
+// InsertString inserts the bytes of s without copying them, as Insert([]byte(s))
+func (cf *CuckooFilter) InsertString(s string) bool {
+	h1, fingerprint := cf.hashString(s)
+	return cf.insert(h1, fingerprint)
+}
 
-	return false
+func (cf *CuckooFilter) ExistString(s string) bool {
+	h1, fingerprint := cf.hashString(s)
+	return cf.lookup(h1, fingerprint)
+}
+
+func (cf *CuckooFilter) DeleteString(s string) bool {
+	h1, fingerprint := cf.hashString(s)
+	return cf.delete(h1, fingerprint)
+}
+
+// InsertUint64 inserts an integer key hashed with filter.NewDigestUint64, see InsertDigest
+func (cf *CuckooFilter) InsertUint64(x uint64) bool {
+	return cf.InsertDigest(filter.NewDigestUint64(x))
+}
+
+func (cf *CuckooFilter) ExistUint64(x uint64) bool {
+	return cf.ExistDigest(filter.NewDigestUint64(x))
+}
+
+func (cf *CuckooFilter) DeleteUint64(x uint64) bool {
+	return cf.DeleteDigest(filter.NewDigestUint64(x))
 }
 
 // returns the fingerprint and the index of the first bucket
 func (cf *CuckooFilter) Hash(data []byte) (uint32, byte) {
-	hash := metro.Hash64(data, cf.Seed)
+	cf.syncFpHashes()
+	if cf.MetroHash {
+		return cf.split(metro.Hash64(data, cf.Seed))
+	}
+	return cf.split(filter.Digest{Hi: filter.DigestHi(data)}.Seeded(cf.Seed)) // Seeded only reads Hi
+}
 
-	h1 := uint32(hash>>32) & (cf.M - 1) // most significant 32 bits
-	fingerprint := byte(hash)           // least significant 8 bits
+func (cf *CuckooFilter) hashString(s string) (uint32, byte) {
+	cf.syncFpHashes()
+	if cf.MetroHash {
+		return cf.split(metro.Hash64Str(s, cf.Seed))
+	}
+	return cf.split(filter.Digest{Hi: filter.DigestHiString(s)}.Seeded(cf.Seed))
+}
+
+func (cf *CuckooFilter) digestHash(d filter.Digest) (uint32, byte) {
+	cf.syncFpHashes()
+	if cf.MetroHash {
+		// filters deserialized from before filter.Digest keep hashing bytes with metro, the
+		// bytes of the digest here, so their digest keys are only found through digests
+		var b [16]byte
+		binary.LittleEndian.PutUint64(b[:8], d.Lo)
+		binary.LittleEndian.PutUint64(b[8:], d.Hi)
+		return cf.split(metro.Hash64(b[:], cf.Seed))
+	}
+	return cf.split(d.Seeded(cf.Seed))
+}
+
+func (cf *CuckooFilter) split(hash uint64) (uint32, byte) {
+	h1 := filter.Reduce(uint32(hash>>32), cf.M) // most significant 32 bits
+	fingerprint := byte(hash)                   // least significant 8 bits
 	if fingerprint == FPNULL {
 		fingerprint = 1
 	}
 	return h1, fingerprint
 }
 
+// InsertBatch inserts keys a window at a time and sets results[i] to Insert(keys[i]), results
+// may be nil. The window is hashed first, so only the kicks wait for the memory accesses.
+func (cf *CuckooFilter) InsertBatch(keys [][]byte, results []bool) {
+	var h1s, h2s [filter.BatchWindow]uint32
+	var fingerprints [filter.BatchWindow]byte
+	for start := 0; start < len(keys); start += filter.BatchWindow {
+		window := keys[start:min(start+filter.BatchWindow, len(keys))]
+		for i, key := range window {
+			h1s[i], fingerprints[i] = cf.Hash(key)
+			h2s[i] = cf.alternateIndex(h1s[i], fingerprints[i])
+		}
+		for i := range window {
+			inserted := cf.BucketInsert(fingerprints[i], h1s[i]) || cf.BucketInsert(fingerprints[i], h2s[i]) ||
+				cf.InsertFingerprint(fingerprints[i], RandomChoise(h1s[i], h2s[i]), 1)
+			if results != nil {
+				results[start+i] = inserted
+			}
+		}
+	}
+}
+
+// ExistBatch sets results[i] to Lookup(keys[i]), results must be at least as long as keys.
+// The window is hashed first, then both buckets of every key are loaded, these independent
+// loads overlap their cache misses before the fingerprints are compared.
+func (cf *CuckooFilter) ExistBatch(keys [][]byte, results []bool) {
+	_ = results[:len(keys)]
+	var h1s, h2s [filter.BatchWindow]uint32
+	var fingerprints [filter.BatchWindow]byte
+	var buckets [filter.BatchWindow][2]uint32
+	for start := 0; start < len(keys); start += filter.BatchWindow {
+		window := keys[start:min(start+filter.BatchWindow, len(keys))]
+		for i, key := range window {
+			h1s[i], fingerprints[i] = cf.Hash(key)
+			h2s[i] = cf.alternateIndex(h1s[i], fingerprints[i])
+		}
+		for i := range window {
+			buckets[i][0] = cf.Buckets[h1s[i]]
+			buckets[i][1] = cf.Buckets[h2s[i]]
+		}
+		for i := range window {
+			results[start+i] = matches(buckets[i][0], fingerprints[i])|matches(buckets[i][1], fingerprints[i]) != 0
+		}
+	}
+}
+
+// AppendHash appends the indexes of both candidate buckets of data to dst,
+// reusing dst avoids any allocation
+func (cf *CuckooFilter) AppendHash(dst []int, data []byte) []int {
+	h1, fingerprint := cf.Hash(data)
+	return append(dst, int(h1), int(cf.alternateIndex(h1, fingerprint)))
+}
+
+// AlternateIndex is its own inverse: a xor when M is a power of two, otherwise a subtraction
+// modulo M, for which the rare index with 2*h1 = fphash mod M is its own alternate.
 func (cf *CuckooFilter) AlternateIndex(h1 uint32, fingerprint byte) uint32 {
-	fphash := uint32(metro.Hash64([]byte{fingerprint}, cf.FpSeed)>>32) & (cf.M - 1)
+	cf.syncFpHashes()
+	return cf.alternateIndex(h1, fingerprint)
+}
 
-	return (h1 ^ fphash)
+// alternateIndex is AlternateIndex without syncing fpHashes, hashing the key already did
+func (cf *CuckooFilter) alternateIndex(h1 uint32, fingerprint byte) uint32 {
+	fphash := cf.fpHashes[fingerprint]
+
+	if cf.M&(cf.M-1) == 0 {
+		return (h1 ^ fphash)
+	}
+	if fphash >= h1 {
+		return fphash - h1
+	}
+	return fphash + cf.M - h1
 }
 
 func (cf *CuckooFilter) BucketInsert(fingerprint byte, h uint32) bool {
-	for i, _ := range cf.Buckets[h] {
-		if cf.Buckets[h][i] == FPNULL {
-			cf.Buckets[h][i] = fingerprint
-			return true
-		}
+	empty := matches(cf.Buckets[h], FPNULL)
+	if empty == 0 {
+		return false
 	}
-	return false
+	cf.Buckets[h] |= uint32(fingerprint) << (bits.TrailingZeros32(empty) &^ 7)
+	return true
+}
+
+// matches returns a word whose byte i has its most significant bit set when the entry i of
+// bucket is fingerprint, with the zero byte test of "Bit Twiddling Hacks". Entries above a
+// match can be flagged spuriously by the borrow, the lowest flagged entry is always a match.
+func matches(bucket uint32, fingerprint byte) uint32 {
+	x := bucket ^ lowBits*uint32(fingerprint)
+	return (x - lowBits) &^ x & highBits
 }
 
 func RandomChoise[T any](a T, b T) T {
@@ -142,21 +329,31 @@ func RandomChoise[T any](a T, b T) T {
 
 // Serialize the filter to a byte slice in the following format:
 // header|buckets
-// header format: uint32(M)|uint64(FpSeed)|uint64(Seed) => 4 + 8 + 8 = 20 bytes
+// header format: uint32(M)|uint64(FpSeed)|uint64(Seed)|byte(flags) => 4 + 8 + 8 + 1 = 21 bytes
+// metro hashing filters have no flags byte, i.e. the 20 bytes header of filters serialized before it
 func (cf *CuckooFilter) Serialize() []byte {
-	buf := bytes.NewBuffer(make([]byte, 0, 12+cf.M*BucketSize))
+	buf := bytes.NewBuffer(make([]byte, 0, 21+cf.M*BucketSize))
 
-	filter.SerializeUint(buf, uint64(cf.M), 4)
-	filter.SerializeUint(buf, cf.FpSeed, 8)
-	filter.SerializeUint(buf, cf.Seed, 8)
+	cf.writeHeader(buf)
 
-	for _, bucket := range cf.Buckets {
+	var bucket [BucketSize]byte
+	for _, b := range cf.Buckets {
+		binary.LittleEndian.PutUint32(bucket[:], b)
 		buf.Write(bucket[:])
 	}
 
 	return buf.Bytes()
 }
 
+func (cf *CuckooFilter) writeHeader(buf *bytes.Buffer) {
+	filter.SerializeUint(buf, uint64(cf.M), 4)
+	filter.SerializeUint(buf, cf.FpSeed, 8)
+	filter.SerializeUint(buf, cf.Seed, 8)
+	if !cf.MetroHash {
+		buf.WriteByte(digestHashing)
+	}
+}
+
 func Deserialize(data []byte) *CuckooFilter {
 	buf := bytes.NewBuffer(data)
 
@@ -164,15 +361,17 @@ func Deserialize(data []byte) *CuckooFilter {
 	fpSeed := filter.DeserializeUint[uint64](buf, 8)
 	seed := filter.DeserializeUint[uint64](buf, 8)
 
-	cf := &CuckooFilter{
-		M:       m,
-		FpSeed:  fpSeed,
-		Seed:    seed,
-		Buckets: make([][BucketSize]byte, m),
+	metroHash := true
+	if len(data)%BucketSize != 0 {
+		flags, _ := buf.ReadByte()
+		metroHash = flags&digestHashing == 0
 	}
 
+	cf := newCuckooFilter(m, seed, fpSeed)
+	cf.MetroHash = metroHash
+
 	for i := range cf.Buckets {
-		buf.Read(cf.Buckets[i][:])
+		cf.Buckets[i] = binary.LittleEndian.Uint32(buf.Next(BucketSize))
 	}
 
 	return cf
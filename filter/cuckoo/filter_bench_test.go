@@ -1,13 +1,16 @@
 package cuckoo_test
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/rag-nar1/Filters/filter"
 	filterCuckoo "github.com/rag-nar1/Filters/filter/cuckoo"
 )
 
@@ -750,3 +753,227 @@ func BenchmarkComparativeSizes(b *testing.B) {
 		})
 	}
 }
+
+// BenchmarkExactSizing compares filters rounded up to a power of two with exactly sized ones,
+// whose buckets are selected with a multiply and shift
+func BenchmarkExactSizing(b *testing.B) {
+	loadFactor := 0.95
+	for _, n := range []uint64{100000, 1000000, 3000000} {
+		rounded := filterCuckoo.NewCuckooFilter(n, loadFactor)
+		exact := filterCuckoo.NewCuckooFilterExact(n, loadFactor)
+		for i := uint64(0); i < n*9/10; i++ {
+			item := []byte(fmt.Sprintf("exact_item_%d", i))
+			rounded.Insert(item)
+			exact.Insert(item)
+		}
+		testData := []byte("exact_item_0")
+
+		b.Run(fmt.Sprintf("n=%d/PowerOfTwo", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				rounded.Lookup(testData)
+			}
+			b.ReportMetric(float64(rounded.M*filterCuckoo.BucketSize)/1024, "memory_KB")
+		})
+		b.Run(fmt.Sprintf("n=%d/Exact", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				exact.Lookup(testData)
+			}
+			b.ReportMetric(float64(exact.M*filterCuckoo.BucketSize)/1024, "memory_KB")
+			b.ReportMetric((1-float64(exact.M)/float64(rounded.M))*100, "memory_saved_%")
+		})
+	}
+}
+
+// BenchmarkBatch compares InsertBatch and ExistBatch with loops over Insert and Lookup on a
+// filter much larger than the caches, where every bucket is a cache miss
+func BenchmarkBatch(b *testing.B) {
+	n := uint64(1 << 24)
+	keys := make([][]byte, 1<<16)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("batch_key_%d", i))
+	}
+	results := make([]bool, len(keys))
+
+	b.Run("Insert", func(b *testing.B) {
+		cf := filterCuckoo.NewCuckooFilter(n, 0.95)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			cf.Insert(keys[i%len(keys)])
+			if i%len(keys) == len(keys)-1 {
+				b.StopTimer()
+				cf = filterCuckoo.NewCuckooFilter(n, 0.95)
+				b.StartTimer()
+			}
+		}
+	})
+	b.Run("InsertBatch", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i += len(keys) {
+			b.StopTimer()
+			cf := filterCuckoo.NewCuckooFilter(n, 0.95)
+			b.StartTimer()
+			cf.InsertBatch(keys[:min(len(keys), b.N-i)], results)
+		}
+	})
+
+	cf := filterCuckoo.NewCuckooFilter(n, 0.95)
+	cf.InsertBatch(keys[:len(keys)/2], nil)
+	b.Run("Lookup", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			results[i%len(keys)] = cf.Lookup(keys[i%len(keys)])
+		}
+	})
+	b.Run("ExistBatch", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i += len(keys) {
+			cf.ExistBatch(keys[:min(len(keys), b.N-i)], results)
+		}
+	})
+}
+
+// BenchmarkHotPath measures single key operations on a filter that fits in the caches, where
+// hashing and bucket scans dominate, and asserts that none of them allocates
+func BenchmarkHotPath(b *testing.B) {
+	n := uint64(1 << 16)
+	cf := filterCuckoo.NewCuckooFilter(n, 0.95)
+	keys := make([][]byte, 2*n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("hot_key_%d", i))
+	}
+	for _, key := range keys[:n*9/10] {
+		cf.Insert(key)
+	}
+	spare := keys[n:] // never inserted, except transiently by InsertDelete
+
+	ops := []struct {
+		name string
+		op   func(i int)
+	}{
+		{"LookupHit", func(i int) { cf.Lookup(keys[i%int(n*9/10)]) }},
+		{"LookupMiss", func(i int) { cf.Lookup(spare[i%len(spare)]) }},
+		{"InsertDelete", func(i int) {
+			key := spare[i%len(spare)]
+			if cf.Insert(key) {
+				cf.Delete(key)
+			}
+		}},
+		{"AlternateIndex", func(i int) { cf.AlternateIndex(uint32(i)&(cf.M-1), byte(i|1)) }},
+	}
+	for _, op := range ops {
+		b.Run(op.name, func(b *testing.B) {
+			if allocs := testing.AllocsPerRun(100, func() { op.op(3) }); allocs != 0 {
+				b.Fatalf("expected 0 allocs/op, got %v", allocs)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				op.op(i)
+			}
+		})
+	}
+}
+
+// BenchmarkConcurrent measures the throughput of the concurrent filter with 1 to 64 goroutines,
+// on lookups and on a mix of 90% lookups and 10% insertions each followed by the deletion of
+// the same key at 85% load, against a CuckooFilter behind a mutex
+func BenchmarkConcurrent(b *testing.B) {
+	n := uint64(1 << 22)
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("concurrent_key_%d", i))
+	}
+	prefill := int(n * 85 / 100)
+
+	ccf := filterCuckoo.NewConcurrentCuckooFilter(n, 0.95)
+	locked := filterCuckoo.NewCuckooFilter(n, 0.95)
+	for _, key := range keys[:prefill] {
+		ccf.Insert(key)
+		locked.Insert(key)
+	}
+	var mu sync.Mutex
+
+	workloads := []struct {
+		name       string
+		concurrent func(i int)
+		mutex      func(i int)
+	}{
+		{"Lookup",
+			func(i int) { ccf.Lookup(keys[i%len(keys)]) },
+			func(i int) { mu.Lock(); locked.Lookup(keys[i%len(keys)]); mu.Unlock() }},
+		{"Mixed",
+			func(i int) {
+				key := keys[i%len(keys)]
+				if i%10 != 0 {
+					ccf.Lookup(key)
+				} else if ccf.Insert(key) {
+					ccf.Delete(key)
+				}
+			},
+			func(i int) {
+				key := keys[i%len(keys)]
+				mu.Lock()
+				if i%10 != 0 {
+					locked.Lookup(key)
+				} else if locked.Insert(key) {
+					locked.Delete(key)
+				}
+				mu.Unlock()
+			}},
+	}
+	for _, w := range workloads {
+		for _, goroutines := range []int{1, 2, 4, 8, 16, 32, 64} {
+			for _, impl := range []struct {
+				name string
+				op   func(i int)
+			}{{"Concurrent", w.concurrent}, {"Mutex", w.mutex}} {
+				b.Run(fmt.Sprintf("%s/goroutines=%d/%s", w.name, goroutines, impl.name), func(b *testing.B) {
+					var wg sync.WaitGroup
+					per := (b.N + goroutines - 1) / goroutines
+					for g := 0; g < goroutines; g++ {
+						wg.Add(1)
+						go func(g int) {
+							defer wg.Done()
+							// goroutines walk the keys with different strides so they do not share cache lines
+							for i := g * per; i < min((g+1)*per, b.N); i++ {
+								impl.op(i * (2*g + 1))
+							}
+						}(g)
+					}
+					wg.Wait()
+					b.ReportMetric(float64(b.N)/b.Elapsed().Seconds()/1e6, "Mops/s")
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkInsertParallel compares building a filter of n keys with Insert and with InsertParallel
+func BenchmarkInsertParallel(b *testing.B) {
+	n := 1 << 22
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("build_key_%d", i))
+	}
+	b.Run("Insert", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cf := filterCuckoo.NewCuckooFilter(uint64(n), 0.95)
+			for _, key := range keys {
+				cf.Insert(key)
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/key")
+	})
+	b.Run("InsertParallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cf := filterCuckoo.NewCuckooFilter(uint64(n), 0.95)
+			if err := cf.InsertParallel(context.Background(), filter.KeysFromSlice(keys), filter.BuildOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/key")
+	})
+}
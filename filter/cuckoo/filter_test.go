@@ -2,10 +2,16 @@ package cuckoo_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math/rand"
 	"runtime"
+	"sync"
 	"testing"
 
+	"github.com/rag-nar1/Filters/filter"
 	filterCuckoo "github.com/rag-nar1/Filters/filter/cuckoo"
 )
 
@@ -108,6 +114,37 @@ func TestAlternateIndex(t *testing.T) {
 	}
 }
 
+func TestAlternateIndexParameters(t *testing.T) {
+	// the alternate buckets follow M and FpSeed however they were set
+	literal := &filterCuckoo.CuckooFilter{M: 1000, Buckets: make([]uint32, 1000), Seed: 1, FpSeed: 2}
+	copied := filterCuckoo.NewCuckooFilter(1000, 0.95)
+	reference := filterCuckoo.NewCuckooFilter(1000, 0.95)
+	copied.Seed, copied.FpSeed = reference.Seed, reference.FpSeed
+
+	for _, tt := range []struct {
+		name      string
+		cf, built *filterCuckoo.CuckooFilter
+	}{
+		{"literal", literal, filterCuckoo.Deserialize(literal.Serialize())},
+		{"copied seeds", copied, reference},
+	} {
+		for h := uint32(0); h < tt.cf.M; h++ {
+			fingerprint := byte(h | 1)
+			if got, want := tt.cf.AlternateIndex(h, fingerprint), tt.built.AlternateIndex(h, fingerprint); got != want {
+				t.Fatalf("%s: alternate index of %d is %d, want %d", tt.name, h, got, want)
+			}
+		}
+		for i := 0; i < 1000; i++ {
+			tt.cf.Insert([]byte(fmt.Sprintf("key_%d", i)))
+		}
+		for i := 0; i < 1000; i++ {
+			if key := []byte(fmt.Sprintf("key_%d", i)); !tt.cf.Lookup(key) {
+				t.Fatalf("%s: false negative for %s", tt.name, key)
+			}
+		}
+	}
+}
+
 func TestInsertAndLookup(t *testing.T) {
 	cf := filterCuckoo.NewCuckooFilter(1000, 0.95)
 
@@ -562,10 +599,503 @@ func TestSerializeDeserialize(t *testing.T) {
 	}
 
 	for i := range cf.Buckets {
-		if !bytes.Equal(cf.Buckets[i][:], deserialized.Buckets[i][:]) {
+		if cf.Buckets[i] != deserialized.Buckets[i] {
 			t.Errorf("Bucket %d mismatch", i)
 		}
 	}
 
 	t.Logf("Serialize and deserialize test passed")
-}
\ No newline at end of file
+}
+
+func TestExactSizing(t *testing.T) {
+	n := uint64(100000)
+	cf := filterCuckoo.NewCuckooFilterExact(n, 0.9)
+	if cf.M&(cf.M-1) == 0 {
+		t.Fatalf("expected a non power of two M, got %d", cf.M)
+	}
+
+	// the alternate index must stay in range and be its own inverse
+	for i := uint32(0); i < cf.M; i += 7 {
+		for _, fingerprint := range []byte{1, 77, 255} {
+			alt := cf.AlternateIndex(i, fingerprint)
+			if alt >= cf.M {
+				t.Fatalf("alternate index %d out of range [0, %d)", alt, cf.M)
+			}
+			if cf.AlternateIndex(alt, fingerprint) != i {
+				t.Fatalf("double alternate should equal original: %d -> %d", i, alt)
+			}
+		}
+	}
+
+	for i := uint64(0); i < n; i++ {
+		if !cf.Insert([]byte(fmt.Sprintf("test_%d", i))) {
+			t.Fatalf("failed to insert test_%d", i)
+		}
+	}
+	deserialized := filterCuckoo.Deserialize(cf.Serialize())
+	for i := uint64(0); i < n; i++ {
+		item := []byte(fmt.Sprintf("test_%d", i))
+		if !cf.Lookup(item) || !deserialized.Lookup(item) {
+			t.Fatalf("false negative for test_%d", i)
+		}
+	}
+	for i := uint64(0); i < n; i++ {
+		if !cf.Delete([]byte(fmt.Sprintf("test_%d", i))) {
+			t.Fatalf("failed to delete test_%d", i)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	n := 10000
+	cf := filterCuckoo.NewCuckooFilter(uint64(n), 0.9)
+
+	keys := make([][]byte, 2*n+5) // not a multiple of the window
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key_%d", i))
+	}
+	inserted := make([]bool, n)
+	cf.InsertBatch(keys[:n], inserted)
+	for i, ok := range inserted {
+		if !ok {
+			t.Fatalf("failed to insert %s", keys[i])
+		}
+	}
+
+	results := make([]bool, len(keys))
+	cf.ExistBatch(keys, results)
+	for i, key := range keys {
+		if results[i] != cf.Lookup(key) {
+			t.Fatalf("ExistBatch and Lookup disagree on %s", key)
+		}
+		if i < n && !results[i] {
+			t.Fatalf("false negative for %s", key)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	n := 10000
+	cf := filterCuckoo.NewCuckooFilter(uint64(n), 0.9)
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		if i%2 == 0 {
+			cf.Insert(key)
+		} else {
+			cf.InsertDigest(filter.NewDigest(key))
+		}
+	}
+	for i := 0; i < 2*n; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		if cf.ExistDigest(filter.NewDigest(key)) != cf.Lookup(key) {
+			t.Fatalf("ExistDigest and Lookup disagree on %s", key)
+		}
+		if i < n && !cf.Lookup(key) {
+			t.Fatalf("false negative for %s", key)
+		}
+	}
+
+	// M is stored as is, every size fits, and the flags byte follows the seeds
+	data := cf.Serialize()
+	if binary.LittleEndian.Uint32(data) != cf.M || data[20] != 1 || len(data) != 21+int(cf.M)*filterCuckoo.BucketSize {
+		t.Fatalf("unexpected header %x for %d buckets", data[:21], cf.M)
+	}
+	deserialized := filterCuckoo.Deserialize(data)
+	if deserialized.MetroHash || deserialized.M != cf.M {
+		t.Fatalf("expected a digest hashing filter of %d buckets, got M=%d MetroHash=%v", cf.M, deserialized.M, deserialized.MetroHash)
+	}
+}
+
+func TestDeserializeMetroHash(t *testing.T) {
+	// filters serialized before digests have a 20 bytes header without flags
+	cf := filterCuckoo.NewCuckooFilter(1000, 0.9)
+	cf.MetroHash = true
+	data := cf.Serialize()
+	if len(data) != 20+int(cf.M)*filterCuckoo.BucketSize {
+		t.Fatal("metro hashing filters must keep the legacy header")
+	}
+	for i := 0; i < 1000; i++ {
+		cf.Insert([]byte(fmt.Sprintf("key_%d", i)))
+	}
+
+	deserialized := filterCuckoo.Deserialize(cf.Serialize())
+	if !deserialized.MetroHash || deserialized.M != cf.M {
+		t.Fatalf("expected a metro hashing filter of %d buckets, got M=%d MetroHash=%v", cf.M, deserialized.M, deserialized.MetroHash)
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		if !deserialized.Lookup([]byte(key)) || !deserialized.ExistString(key) {
+			t.Fatalf("false negative for %s", key)
+		}
+	}
+
+	// digest keys are hashed from the digest, they are found through digests only
+	for i := 1000; i < 1100; i++ {
+		if !deserialized.InsertDigest(filter.NewDigest([]byte(fmt.Sprintf("key_%d", i)))) ||
+			!deserialized.InsertUint64(uint64(i)) {
+			t.Fatalf("failed to insert %d", i)
+		}
+	}
+	paged, concurrent := deserialized.Paged(), filterCuckoo.Deserialize(deserialized.Serialize()).Concurrent()
+	sharded := filter.NewSharded(4, func(int) *filterCuckoo.CuckooFilter {
+		return filterCuckoo.Deserialize(data)
+	})
+	for i := 1000; i < 1100; i++ {
+		d := filter.NewDigest([]byte(fmt.Sprintf("key_%d", i)))
+		if !deserialized.ExistDigest(d) || !paged.ExistDigest(d) || !concurrent.ExistDigest(d) {
+			t.Fatalf("false negative for the digest of key_%d", i)
+		}
+		if !deserialized.ExistUint64(uint64(i)) || !paged.ExistUint64(uint64(i)) {
+			t.Fatalf("false negative for %d", i)
+		}
+		if !sharded.InsertUint64(uint64(i)) || !sharded.ExistUint64(uint64(i)) {
+			t.Fatalf("sharded metro hashing filters lost %d", i)
+		}
+	}
+}
+
+func TestBucketLayout(t *testing.T) {
+	cf := filterCuckoo.NewCuckooFilter(64, 0.95)
+	// fill a bucket, its entries are its bytes from the least significant one
+	for _, fingerprint := range []byte{0x11, 0x80, 0x01, 0xff} {
+		if !cf.BucketInsert(fingerprint, 3) {
+			t.Fatalf("failed to insert %#x", fingerprint)
+		}
+	}
+	if cf.BucketInsert(0x22, 3) {
+		t.Fatal("inserted in a full bucket")
+	}
+	if cf.Buckets[3] != 0xff018011 {
+		t.Fatalf("expected bucket 0xff018011, got %#x", cf.Buckets[3])
+	}
+	// the serialized buckets keep one byte per entry, in order
+	data := cf.Serialize()
+	if !bytes.Equal(data[21+3*filterCuckoo.BucketSize:21+4*filterCuckoo.BucketSize], []byte{0x11, 0x80, 0x01, 0xff}) {
+		t.Fatalf("unexpected serialized bucket %x", data[21+3*filterCuckoo.BucketSize:21+4*filterCuckoo.BucketSize])
+	}
+}
+
+func TestBucketMatching(t *testing.T) {
+	// fill the buckets of a key with entries next to its fingerprint, where the borrow of the
+	// zero byte test could flag a wrong entry, and compare Lookup and Delete with a byte scan
+	cf := filterCuckoo.NewCuckooFilter(1<<10, 0.95)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("match_%d", i))
+		h1, fingerprint := cf.Hash(key)
+		h2 := cf.AlternateIndex(h1, fingerprint)
+		candidates := []byte{0, 1, fingerprint - 1, fingerprint, fingerprint + 1, fingerprint ^ 0x80, fingerprint ^ 1, 0xff}
+		for _, h := range []uint32{h1, h2} {
+			cf.Buckets[h] = 0
+			for j := 0; j < filterCuckoo.BucketSize; j++ {
+				cf.Buckets[h] |= uint32(candidates[rng.Intn(len(candidates))]) << (8 * j)
+			}
+		}
+
+		count := func() int {
+			n := 0
+			for _, h := range []uint32{h1, h2} {
+				for j := 0; j < filterCuckoo.BucketSize; j++ {
+					if byte(cf.Buckets[h]>>(8*j)) == fingerprint {
+						n++
+					}
+				}
+			}
+			if h1 == h2 {
+				n /= 2
+			}
+			return n
+		}
+		expected := count()
+		if cf.Lookup(key) != (expected > 0) {
+			t.Fatalf("Lookup of %s is %v with %d matching entries", key, cf.Lookup(key), expected)
+		}
+		if cf.Delete(key) != (expected > 0) {
+			t.Fatalf("Delete of %s with %d matching entries", key, expected)
+		}
+		if expected > 0 && count() != expected-1 {
+			t.Fatalf("Delete of %s removed %d entries", key, expected-count())
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	const goroutines, perGoroutine = 16, 5000
+	for _, ccf := range []*filterCuckoo.ConcurrentCuckooFilter{
+		filterCuckoo.NewConcurrentCuckooFilter(goroutines*perGoroutine, 0.95),
+		filterCuckoo.NewConcurrentCuckooFilterExact(goroutines*perGoroutine, 0.9),
+	} {
+		var wg sync.WaitGroup
+		errs := make(chan string, goroutines)
+		inserted := make([][]bool, goroutines)
+		for g := 0; g < goroutines; g++ {
+			inserted[g] = make([]bool, perGoroutine)
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < perGoroutine; i++ {
+					key := fmt.Sprintf("key_%d_%d", g, i)
+					inserted[g][i] = ccf.InsertString(key)
+					// a key must be found as soon as its Insert returned, while other writers move entries
+					if inserted[g][i] && !ccf.ExistString(key) {
+						errs <- "false negative for " + key
+						return
+					}
+					// delete a third of the keys of the previous rounds
+					if i%3 == 0 && i > 0 && inserted[g][i-1] {
+						if !ccf.DeleteString(fmt.Sprintf("key_%d_%d", g, i-1)) {
+							errs <- fmt.Sprintf("failed to delete key_%d_%d", g, i-1)
+							return
+						}
+						inserted[g][i-1] = false
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+
+		failed := 0
+		snapshot := ccf.Snapshot()
+		for g := range inserted {
+			for i, ok := range inserted[g] {
+				key := []byte(fmt.Sprintf("key_%d_%d", g, i))
+				if !ok {
+					failed++
+					continue
+				}
+				if !ccf.Lookup(key) || !snapshot.Lookup(key) {
+					t.Fatalf("false negative for %s", key)
+				}
+			}
+		}
+		t.Logf("M=%d: %d keys not inserted or deleted", snapshot.M, failed)
+	}
+}
+
+func TestConcurrentFull(t *testing.T) {
+	// insertions beyond the capacity fail without evicting the keys already inserted
+	ccf := filterCuckoo.NewConcurrentCuckooFilter(1000, 1)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var inserted [][]byte
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := []byte(fmt.Sprintf("key_%d_%d", g, i))
+				if ccf.Insert(key) {
+					mu.Lock()
+					inserted = append(inserted, key)
+					mu.Unlock()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if len(inserted) == 8*500 {
+		t.Fatal("expected some insertions to fail")
+	}
+	for _, key := range inserted {
+		if !ccf.Lookup(key) {
+			t.Fatalf("false negative for %s", key)
+		}
+	}
+}
+
+func TestInsertParallel(t *testing.T) {
+	n := 200000
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key_%d", i))
+	}
+	for _, cf := range []*filterCuckoo.CuckooFilter{
+		filterCuckoo.NewCuckooFilter(uint64(n), 0.95),
+		filterCuckoo.NewCuckooFilterExact(uint64(n), 0.95),
+	} {
+		if err := cf.InsertParallel(context.Background(), filter.KeysFromSlice(keys), filter.BuildOptions{Workers: 6}); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			if !cf.Lookup(key) {
+				t.Fatalf("M=%d: false negative for %s", cf.M, key)
+			}
+		}
+		stored := 0
+		for _, bucket := range cf.Buckets {
+			for j := 0; j < filterCuckoo.BucketSize; j++ {
+				if byte(bucket>>(8*j)) != filterCuckoo.FPNULL {
+					stored++
+				}
+			}
+		}
+		if stored != n {
+			t.Fatalf("M=%d: expected %d entries, got %d", cf.M, n, stored)
+		}
+	}
+
+	// keys beyond the capacity are reported, the others are all inserted
+	cf := filterCuckoo.NewCuckooFilterExact(10000, 1)
+	err := cf.InsertParallel(context.Background(), filter.KeysFromSlice(keys[:11000]), filter.BuildOptions{Workers: 6})
+	if !errors.Is(err, filterCuckoo.ErrFull) {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+	t.Log(err)
+
+	// metro hashing filters would only find the keys through their digests
+	metro := filterCuckoo.NewCuckooFilter(1000, 0.9)
+	metro.MetroHash = true
+	err = metro.InsertParallel(context.Background(), filter.KeysFromSlice(keys[:100]), filter.BuildOptions{})
+	if !errors.Is(err, filterCuckoo.ErrMetroHash) || metro.Lookup(keys[0]) {
+		t.Fatalf("expected ErrMetroHash and no insertion, got %v", err)
+	}
+}
+
+func TestPaged(t *testing.T) {
+	const n = 50000
+	cf := filterCuckoo.NewCuckooFilter(2*n, 0.9)
+	pcf := filterCuckoo.Deserialize(cf.Serialize()).Paged()
+	for i := 0; i < n; i++ {
+		if !pcf.InsertUint64(uint64(i)) {
+			t.Fatalf("failed to insert %d", i)
+		}
+	}
+	snapshot := pcf.Snapshot()
+	pages := pcf.SharedPages()
+	serialized := make(chan []byte)
+	go func() {
+		var buf bytes.Buffer
+		snapshot.WriteTo(&buf)
+		serialized <- buf.Bytes()
+	}()
+
+	// a few deletions copy at most their pages
+	for i := 0; i < 10; i++ {
+		pcf.DeleteUint64(uint64(i))
+	}
+	if shared := pcf.SharedPages(); shared < pages-10 {
+		t.Fatalf("10 deletions copied too many pages, %d shared", shared)
+	}
+	for i := n; i < 2*n; i++ {
+		pcf.InsertUint64(uint64(i))
+	}
+
+	restored := filterCuckoo.Deserialize(<-serialized)
+	for i := 0; i < n; i++ {
+		if !restored.ExistUint64(uint64(i)) {
+			t.Fatalf("key %d inserted before the snapshot not found", i)
+		}
+		if i >= 10 && !pcf.ExistUint64(uint64(i)) {
+			t.Fatalf("key %d not found", i)
+		}
+	}
+	if !bytes.Equal(filterCuckoo.Deserialize(pcf.Serialize()).Serialize(), pcf.Serialize()) {
+		t.Fatal("the paged filter does not serialize as CuckooFilter")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	const n = 20000
+	template := filterCuckoo.NewCuckooFilter(2*n, 0.9)
+	shards := []*filterCuckoo.CuckooFilter{
+		filterCuckoo.Deserialize(template.Serialize()),
+		filterCuckoo.Deserialize(template.Serialize()),
+	}
+	for i := 0; i < n; i++ {
+		if !shards[i%2].InsertUint64(uint64(i)) {
+			t.Fatalf("failed to insert %d", i)
+		}
+	}
+	entries := func(cf *filterCuckoo.CuckooFilter) int {
+		count := 0
+		cf.Fingerprints(func(uint32, byte) bool {
+			count++
+			return true
+		})
+		return count
+	}
+	if entries(shards[0]) != n/2 {
+		t.Fatalf("expected %d fingerprints, got %d", n/2, entries(shards[0]))
+	}
+
+	if err := shards[0].Merge(shards[1]); err != nil {
+		t.Fatal(err)
+	}
+	if entries(shards[0]) != n {
+		t.Fatalf("expected %d fingerprints after the merge, got %d", n, entries(shards[0]))
+	}
+	for i := 0; i < n; i++ {
+		if !shards[0].ExistUint64(uint64(i)) {
+			t.Fatalf("key %d lost by the merge", i)
+		}
+	}
+
+	// merging a filter into itself stores every key twice, until it overflows
+	var err error
+	var before int
+	var original *filterCuckoo.CuckooFilter
+	for attempt := 0; attempt < 4 && err == nil; attempt++ {
+		before, original = entries(shards[0]), filterCuckoo.Deserialize(shards[0].Serialize())
+		err = shards[0].Merge(shards[0])
+	}
+	if !errors.Is(err, filterCuckoo.ErrFull) {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+	if after := entries(shards[0]); after <= before || after >= 2*before {
+		t.Fatalf("expected a partial merge, %d fingerprints before and %d after", before, after)
+	}
+	// no entry of the filter is evicted by a failed merge
+	original.Fingerprints(func(h uint32, fingerprint byte) bool {
+		merged := shards[0].Buckets
+		found := false
+		for _, b := range []uint32{h, shards[0].AlternateIndex(h, fingerprint)} {
+			for shift := 0; shift < 32; shift += 8 {
+				found = found || byte(merged[b]>>shift) == fingerprint
+			}
+		}
+		if !found {
+			t.Fatalf("fingerprint %d of bucket %d lost by a failed merge", fingerprint, h)
+		}
+		return true
+	})
+	for i := 0; i < n; i++ {
+		if !shards[0].ExistUint64(uint64(i)) {
+			t.Fatalf("key %d lost by a failed merge", i)
+		}
+	}
+
+	other := filterCuckoo.NewCuckooFilter(2*n, 0.9)
+	if err := shards[1].Merge(other); !errors.Is(err, filterCuckoo.ErrIncompatible) {
+		t.Fatalf("filters of different seeds must not be merged, got %v", err)
+	}
+}
+
+func TestMergeCopiedSeeds(t *testing.T) {
+	// filters made Compatible by assigning the seeds of one to the other merge without losing
+	// keys, whether the filter receiving them was filled after the assignment or not at all
+	const n = 10000
+	a := filterCuckoo.NewCuckooFilterExact(2*n, 0.9)
+	b := filterCuckoo.NewCuckooFilterExact(2*n, 0.9)
+	empty := filterCuckoo.NewCuckooFilterExact(2*n, 0.9)
+	b.Seed, b.FpSeed = a.Seed, a.FpSeed
+	for i := 0; i < n; i++ {
+		a.InsertUint64(uint64(i))
+		b.InsertUint64(uint64(n + i))
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	empty.Seed, empty.FpSeed = a.Seed, a.FpSeed
+	if err := empty.Merge(a); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2*n; i++ {
+		if !a.ExistUint64(uint64(i)) || !empty.ExistUint64(uint64(i)) {
+			t.Fatalf("key %d lost by the merge", i)
+		}
+	}
+}
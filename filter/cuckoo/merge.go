@@ -0,0 +1,100 @@
+package cuckoo
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+)
+
+var ErrIncompatible = errors.New("cuckoo: filters have different parameters")
+
+// Compatible returns an error wrapping ErrIncompatible unless cf and other have the same number
+// of buckets, seeds and hashing, so that a key gets the same fingerprint and buckets in both
+func (cf *CuckooFilter) Compatible(other *CuckooFilter) error {
+	switch {
+	case cf.M != other.M:
+		return fmt.Errorf("%w: M %d and %d", ErrIncompatible, cf.M, other.M)
+	case cf.Seed != other.Seed || cf.FpSeed != other.FpSeed:
+		return fmt.Errorf("%w: different seeds", ErrIncompatible)
+	case cf.MetroHash != other.MetroHash:
+		return fmt.Errorf("%w: keys hashed with metro in one filter only", ErrIncompatible)
+	}
+	return nil
+}
+
+// Fingerprints calls yield with the bucket and the fingerprint of every entry, bucket after
+// bucket, and stops as soon as yield returns false. It has the shape of iter.Seq2[uint32, byte],
+// the filter must not be modified meanwhile.
+func (cf *CuckooFilter) Fingerprints(yield func(bucket uint32, fingerprint byte) bool) {
+	for h, bucket := range cf.Buckets {
+		for shift := 0; shift < BucketSize*FpSize; shift += FpSize {
+			if fingerprint := byte(bucket >> shift); fingerprint != FPNULL && !yield(uint32(h), fingerprint) {
+				return
+			}
+		}
+	}
+}
+
+// Merge inserts every entry of other into cf, which then holds the keys of both filters: they
+// must be Compatible, e.g. deserialized from a common filter, so that a fingerprint has the same
+// pair of buckets in both. A key of both filters is kept twice, as a key inserted twice, so
+// it can still be deleted once for each filter.
+// The entries of other that find no slot are dropped without evicting any entry of cf, Merge
+// then returns an error wrapping ErrFull with their number.
+func (cf *CuckooFilter) Merge(other *CuckooFilter) error {
+	if err := cf.Compatible(other); err != nil {
+		return err
+	}
+	cf.syncFpHashes() // the seeds of cf may have been assigned since it last hashed a key
+	if other == cf {
+		clone := *cf
+		clone.Buckets = slices.Clone(cf.Buckets)
+		other = &clone
+	}
+	failed := 0
+	other.Fingerprints(func(h uint32, fingerprint byte) bool {
+		if !cf.insertOrRollback(h, fingerprint) {
+			failed++
+		}
+		return true
+	})
+	if failed > 0 {
+		return fmt.Errorf("%w: %d fingerprints not merged", ErrFull, failed)
+	}
+	return nil
+}
+
+// insertOrRollback inserts fingerprint into bucket h or its alternate bucket as insert does,
+// but puts the kicked fingerprints back when no slot is found, so a failed insertion leaves
+// the filter unchanged
+func (cf *CuckooFilter) insertOrRollback(h uint32, fingerprint byte) bool {
+	alternate := cf.alternateIndex(h, fingerprint)
+	return cf.BucketInsert(fingerprint, h) || cf.BucketInsert(fingerprint, alternate) ||
+		cf.insertKicking(fingerprint, RandomChoise(h, alternate))
+}
+
+// insertKicking is InsertFingerprint recording its kicks, the kick buffer lives in this
+// function so insertions into a free slot do not clear it
+func (cf *CuckooFilter) insertKicking(fingerprint byte, h uint32) bool {
+	var kicks [MaxKicks]move
+	for i := range kicks {
+		if cf.BucketInsert(fingerprint, h) {
+			return true
+		}
+		shift := uint32(rand.Intn(BucketSize)) * FpSize
+		kicked := byte(cf.Buckets[h] >> shift)
+		kicks[i] = move{from: h, shift: shift, fingerprint: kicked}
+		cf.Buckets[h] = cf.Buckets[h]&^(0xff<<shift) | uint32(fingerprint)<<shift
+		fingerprint, h = kicked, cf.alternateIndex(h, kicked)
+	}
+	if cf.BucketInsert(fingerprint, h) {
+		return true
+	}
+	// every kick only wrote its slot, restoring them backwards restores the filter
+	for i := len(kicks) - 1; i >= 0; i-- {
+		k := kicks[i]
+		cf.Buckets[k.from] = cf.Buckets[k.from]&^(0xff<<k.shift) | uint32(k.fingerprint)<<k.shift
+	}
+	return false
+}
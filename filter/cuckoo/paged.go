@@ -0,0 +1,189 @@
+package cuckoo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"math/rand"
+
+	"github.com/rag-nar1/Filters/filter"
+)
+
+// PagedCuckooFilter is a CuckooFilter storing its buckets in filter.Pages, so that Snapshot
+// takes constant time and the writes following it copy only the pages they touch. A snapshot
+// never changes, it can be serialized by another goroutine while the filter keeps being
+// written. Like CuckooFilter, it is not safe for concurrent writes.
+type PagedCuckooFilter struct {
+	cf      *CuckooFilter // parameters and hashing of the filter, its Buckets are not used
+	buckets *filter.Pages[uint32]
+}
+
+func NewPagedCuckooFilter(n uint64, loadFactor float64) *PagedCuckooFilter {
+	return NewCuckooFilter(n, loadFactor).Paged()
+}
+
+func NewPagedCuckooFilterExact(n uint64, loadFactor float64) *PagedCuckooFilter {
+	return NewCuckooFilterExact(n, loadFactor).Paged()
+}
+
+// Paged returns a PagedCuckooFilter holding a copy of the buckets of cf
+func (cf *CuckooFilter) Paged() *PagedCuckooFilter {
+	cf.syncFpHashes()
+	params := *cf
+	params.Buckets = nil
+	return &PagedCuckooFilter{cf: &params, buckets: filter.PagesFromSlice(cf.Buckets)}
+}
+
+func (pcf *PagedCuckooFilter) Insert(data []byte) bool {
+	h1, fingerprint := pcf.cf.Hash(data)
+	return pcf.insert(h1, fingerprint)
+}
+
+func (pcf *PagedCuckooFilter) Lookup(data []byte) bool {
+	h1, fingerprint := pcf.cf.Hash(data)
+	return pcf.lookup(h1, fingerprint)
+}
+
+func (pcf *PagedCuckooFilter) Delete(data []byte) bool {
+	h1, fingerprint := pcf.cf.Hash(data)
+	return pcf.delete(h1, fingerprint)
+}
+
+func (pcf *PagedCuckooFilter) InsertDigest(d filter.Digest) bool {
+	h1, fingerprint := pcf.cf.digestHash(d)
+	return pcf.insert(h1, fingerprint)
+}
+
+func (pcf *PagedCuckooFilter) ExistDigest(d filter.Digest) bool {
+	h1, fingerprint := pcf.cf.digestHash(d)
+	return pcf.lookup(h1, fingerprint)
+}
+
+func (pcf *PagedCuckooFilter) DeleteDigest(d filter.Digest) bool {
+	h1, fingerprint := pcf.cf.digestHash(d)
+	return pcf.delete(h1, fingerprint)
+}
+
+func (pcf *PagedCuckooFilter) InsertString(s string) bool {
+	h1, fingerprint := pcf.cf.hashString(s)
+	return pcf.insert(h1, fingerprint)
+}
+
+func (pcf *PagedCuckooFilter) ExistString(s string) bool {
+	h1, fingerprint := pcf.cf.hashString(s)
+	return pcf.lookup(h1, fingerprint)
+}
+
+func (pcf *PagedCuckooFilter) DeleteString(s string) bool {
+	h1, fingerprint := pcf.cf.hashString(s)
+	return pcf.delete(h1, fingerprint)
+}
+
+func (pcf *PagedCuckooFilter) InsertUint64(x uint64) bool {
+	return pcf.InsertDigest(filter.NewDigestUint64(x))
+}
+
+func (pcf *PagedCuckooFilter) ExistUint64(x uint64) bool {
+	return pcf.ExistDigest(filter.NewDigestUint64(x))
+}
+
+func (pcf *PagedCuckooFilter) DeleteUint64(x uint64) bool {
+	return pcf.DeleteDigest(filter.NewDigestUint64(x))
+}
+
+// insert follows CuckooFilter.insert, kicks included
+func (pcf *PagedCuckooFilter) insert(h1 uint32, fingerprint byte) bool {
+	if pcf.bucketInsert(fingerprint, h1) {
+		return true
+	}
+	h2 := pcf.cf.alternateIndex(h1, fingerprint)
+	if pcf.bucketInsert(fingerprint, h2) {
+		return true
+	}
+	h := RandomChoise(h1, h2)
+	for kick := 1; kick <= MaxKicks; kick++ {
+		if pcf.bucketInsert(fingerprint, h) {
+			return true
+		}
+		shift := uint32(rand.Intn(BucketSize)) * FpSize
+		bucket := pcf.buckets.Get(int(h))
+		kickedFingerprint := byte(bucket >> shift)
+		pcf.buckets.Set(int(h), bucket&^(0xff<<shift)|uint32(fingerprint)<<shift)
+
+		fingerprint, h = kickedFingerprint, pcf.cf.alternateIndex(h, kickedFingerprint)
+	}
+	return false
+}
+
+func (pcf *PagedCuckooFilter) lookup(h1 uint32, fingerprint byte) bool {
+	h2 := pcf.cf.alternateIndex(h1, fingerprint)
+	return matches(pcf.buckets.Get(int(h1)), fingerprint)|matches(pcf.buckets.Get(int(h2)), fingerprint) != 0
+}
+
+func (pcf *PagedCuckooFilter) delete(h1 uint32, fingerprint byte) bool {
+	return pcf.bucketDelete(fingerprint, h1) || pcf.bucketDelete(fingerprint, pcf.cf.alternateIndex(h1, fingerprint))
+}
+
+func (pcf *PagedCuckooFilter) bucketInsert(fingerprint byte, h uint32) bool {
+	bucket := pcf.buckets.Get(int(h))
+	empty := matches(bucket, FPNULL)
+	if empty == 0 {
+		return false
+	}
+	pcf.buckets.Set(int(h), bucket|uint32(fingerprint)<<(bits.TrailingZeros32(empty)&^7))
+	return true
+}
+
+func (pcf *PagedCuckooFilter) bucketDelete(fingerprint byte, h uint32) bool {
+	bucket := pcf.buckets.Get(int(h))
+	match := matches(bucket, fingerprint)
+	if match == 0 {
+		return false
+	}
+	pcf.buckets.Set(int(h), bucket&^(0xff<<(bits.TrailingZeros32(match)&^7)))
+	return true
+}
+
+// Snapshot returns a copy of the filter in constant time, the pages of buckets are shared
+// until either filter writes them
+func (pcf *PagedCuckooFilter) Snapshot() *PagedCuckooFilter {
+	return &PagedCuckooFilter{cf: pcf.cf, buckets: pcf.buckets.Snapshot()}
+}
+
+// SharedPages returns the number of pages of buckets still shared with a snapshot
+func (pcf *PagedCuckooFilter) SharedPages() int {
+	return pcf.buckets.SharedPages()
+}
+
+// WriteTo writes the filter in the format of CuckooFilter.Serialize a page at a time, without
+// holding the whole serialized filter in memory
+func (pcf *PagedCuckooFilter) WriteTo(w io.Writer) (int64, error) {
+	header := bytes.NewBuffer(make([]byte, 0, 21))
+	pcf.cf.writeHeader(header)
+	n, err := w.Write(header.Bytes())
+	written := int64(n)
+
+	buf := make([]byte, 0, filter.PageWords*BucketSize)
+	pcf.buckets.Range(func(buckets []uint32) bool {
+		if err != nil {
+			return false
+		}
+		buf = buf[:0]
+		for _, bucket := range buckets {
+			buf = binary.LittleEndian.AppendUint32(buf, bucket)
+		}
+		n, err = w.Write(buf)
+		written += int64(n)
+		return err == nil
+	})
+	return written, err
+}
+
+// Serialize the filter in the format of CuckooFilter.Serialize, Deserialize(data).Paged()
+// restores it
+func (pcf *PagedCuckooFilter) Serialize() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 21+pcf.buckets.Len()*BucketSize))
+	pcf.WriteTo(buf)
+	return buf.Bytes()
+}
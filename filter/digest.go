@@ -0,0 +1,51 @@
+package filter
+
+import "github.com/zeebo/xxh3"
+
+// Digest is a 128 bits hash of a key computed once and reused to query many filters of
+// different sizes and seeds, each filter derives its indexes from it instead of rehashing the key.
+//
+// Lo is the xxh3 hash of the key, the one bloom filters have always used, and Hi its xxh3 hash
+// seeded with digestSeed, an independent hash. Every filter reads a single half, bloom filters
+// Lo and cuckoo filters Hi, so their []byte and string methods hash keys once, and filters sharing
+// a digest get independent hashes from it. Integer keys have 64 bits only, see NewDigestUint64.
+type Digest struct {
+	Lo uint64
+	Hi uint64
+}
+
+// digestSeed seeds the hash of Hi, any value other than 0 makes it independent of Lo
+const digestSeed = 0x9e3779b97f4a7c15
+
+func NewDigest(data []byte) Digest {
+	return Digest{Lo: xxh3.Hash(data), Hi: xxh3.HashSeed(data, digestSeed)}
+}
+
+// NewDigestString returns the digest of the bytes of s without copying them,
+// it equals NewDigest([]byte(s))
+func NewDigestString(s string) Digest {
+	return Digest{Lo: xxh3.HashString(s), Hi: xxh3.HashStringSeed(s, digestSeed)}
+}
+
+// DigestHi returns NewDigest(data).Hi without hashing the Lo half
+func DigestHi(data []byte) uint64 {
+	return xxh3.HashSeed(data, digestSeed)
+}
+
+// DigestHiString returns NewDigestString(s).Hi without hashing the Lo half
+func DigestHiString(s string) uint64 {
+	return xxh3.HashStringSeed(s, digestSeed)
+}
+
+// NewDigestUint64 returns the digest of an integer key with a bijective mixer instead of
+// hashing its bytes, so distinct keys never share Lo. Hi is mixed from Lo, the key has no more
+// than 64 bits to give. It does not equal the digest of any encoding of x.
+func NewDigestUint64(x uint64) Digest {
+	lo := Mix64(x + 0x632be59bd9b4e019)
+	return Digest{Lo: lo, Hi: Mix64(lo + digestSeed)}
+}
+
+// Seeded returns the 64 bits of the digest specific to a filter seed
+func (d Digest) Seeded(seed uint64) uint64 {
+	return Mix64(d.Hi ^ seed)
+}
@@ -0,0 +1,309 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"slices"
+
+	"github.com/rag-nar1/Filters/filter"
+)
+
+// parameters of the BIP158 basic block filter
+const (
+	BIP158P = 19
+	BIP158M = 784931
+)
+
+var ErrInvalidEncoding = errors.New("gcs: invalid filter encoding")
+
+// GCSFilter is a Golomb-coded set: every item is hashed into [0, N*M), the hashes
+// are sorted and the differences between consecutive hashes are Golomb-Rice coded
+// with parameter P, which needs about P + 2 bits per item.
+// The false positive rate is 1/M, M = 2^P is the usual choice while BIP158 uses
+// a slightly smaller M to minimize the filter size.
+type GCSFilter struct {
+	N   uint32 // number of items
+	P   uint8  // number of bits of the remainder of each delta
+	M   uint64 // inverse of the false positive rate
+	Key [16]byte
+
+	Data []byte // golomb-rice coded deltas
+}
+
+// NewGCSFilter builds the filter of the distinct items of the set.
+func NewGCSFilter(items [][]byte, p uint8, m uint64, key [16]byte) *GCSFilter {
+	distinct := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		distinct[string(item)] = struct{}{}
+	}
+
+	gf := &GCSFilter{
+		N:   uint32(len(distinct)),
+		P:   p,
+		M:   m,
+		Key: key,
+	}
+
+	values := make([]uint64, 0, len(distinct))
+	for item := range distinct {
+		values = append(values, gf.hash([]byte(item)))
+	}
+	slices.Sort(values)
+
+	w := &bitWriter{}
+	last := uint64(0)
+	for _, value := range values {
+		w.writeGolombRice(value-last, p)
+		last = value
+	}
+	gf.Data = w.bytes()
+	return gf
+}
+
+// NewBIP158Filter builds a BIP158 basic filter of a block, blockHash is in internal byte order.
+func NewBIP158Filter(blockHash [32]byte, elements [][]byte) *GCSFilter {
+	return NewGCSFilter(elements, BIP158P, BIP158M, BIP158Key(blockHash))
+}
+
+// BIP158Key returns the SipHash key of a block filter, the first 16 bytes of the block hash.
+func BIP158Key(blockHash [32]byte) [16]byte {
+	var key [16]byte
+	copy(key[:], blockHash[:16])
+	return key
+}
+
+// Match reports whether item may be in the set, the stream is decoded
+// only until the first value that is not smaller than the hash of item.
+func (gf *GCSFilter) Match(item []byte) bool {
+	if gf.N == 0 {
+		return false
+	}
+	target := gf.hash(item)
+
+	r := bitReader{data: gf.Data}
+	value := uint64(0)
+	for range gf.N {
+		delta, ok := r.readGolombRice(gf.P)
+		if !ok {
+			return false
+		}
+		value += delta
+		if value >= target {
+			return value == target
+		}
+	}
+	return false
+}
+
+// MatchAny reports whether any of items may be in the set, the sorted hashes
+// of items are matched against the stream in a single pass.
+func (gf *GCSFilter) MatchAny(items [][]byte) bool {
+	if gf.N == 0 || len(items) == 0 {
+		return false
+	}
+	targets := make([]uint64, len(items))
+	for i, item := range items {
+		targets[i] = gf.hash(item)
+	}
+	slices.Sort(targets)
+
+	r := bitReader{data: gf.Data}
+	value := uint64(0)
+	t := 0
+	for range gf.N {
+		delta, ok := r.readGolombRice(gf.P)
+		if !ok {
+			return false
+		}
+		value += delta
+		for targets[t] < value {
+			t++
+			if t == len(targets) {
+				return false
+			}
+		}
+		if targets[t] == value {
+			return true
+		}
+	}
+	return false
+}
+
+// AppendHash appends the value of item in [0, N*M) to dst, the one searched in the
+// decoded stream, reusing dst avoids any allocation
+func (gf *GCSFilter) AppendHash(dst []int, item []byte) []int {
+	return append(dst, int(gf.hash(item)))
+}
+
+// hash maps item to [0, N*M) with a multiply and shift instead of a modulo
+func (gf *GCSFilter) hash(item []byte) uint64 {
+	k0 := binary.LittleEndian.Uint64(gf.Key[:8])
+	k1 := binary.LittleEndian.Uint64(gf.Key[8:])
+	hi, _ := bits.Mul64(sipHash(k0, k1, item), uint64(gf.N)*gf.M)
+	return hi
+}
+
+// BIP158Bytes encodes the filter as in BIP158: CompactSize(N)|golomb-rice coded deltas
+func (gf *GCSFilter) BIP158Bytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 9+len(gf.Data)))
+	writeCompactSize(buf, uint64(gf.N))
+	buf.Write(gf.Data)
+	return buf.Bytes()
+}
+
+// DeserializeBIP158 decodes a BIP158 basic filter of the block with hash blockHash, in internal byte order.
+func DeserializeBIP158(blockHash [32]byte, data []byte) (*GCSFilter, error) {
+	buf := bytes.NewBuffer(data)
+	n, err := readCompactSize(buf)
+	if err != nil {
+		return nil, err
+	}
+	if n > 1<<32-1 {
+		return nil, ErrInvalidEncoding
+	}
+	return &GCSFilter{
+		N:    uint32(n),
+		P:    BIP158P,
+		M:    BIP158M,
+		Key:  BIP158Key(blockHash),
+		Data: slices.Clone(buf.Bytes()),
+	}, nil
+}
+
+// Serialize the filter to a byte slice in the following format:
+// header|data
+// header format: uint32(N)|uint8(P)|uint64(M)|Key => 4 + 1 + 8 + 16 = 29 bytes
+func (gf *GCSFilter) Serialize() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 29+len(gf.Data)))
+	filter.SerializeUint(buf, uint64(gf.N), 4)
+	filter.SerializeUint(buf, uint64(gf.P), 1)
+	filter.SerializeUint(buf, gf.M, 8)
+	buf.Write(gf.Key[:])
+	buf.Write(gf.Data)
+	return buf.Bytes()
+}
+
+func Deserialize(data []byte) *GCSFilter {
+	buf := bytes.NewBuffer(data)
+	gf := &GCSFilter{
+		N: filter.DeserializeUint[uint32](buf, 4),
+		P: uint8(filter.DeserializeUint[uint32](buf, 1)),
+		M: filter.DeserializeUint[uint64](buf, 8),
+	}
+	buf.Read(gf.Key[:])
+	gf.Data = slices.Clone(buf.Bytes())
+	return gf
+}
+
+// bitWriter appends bits most significant first, as BIP158 requires
+type bitWriter struct {
+	data  []byte
+	nbits uint8 // bits used in the last byte
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.nbits == 0 {
+		w.data = append(w.data, 0)
+		w.nbits = 8
+	}
+	w.nbits--
+	if bit {
+		w.data[len(w.data)-1] |= 1 << w.nbits
+	}
+}
+
+func (w *bitWriter) writeBits(value uint64, n uint8) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.writeBit((value>>i)&1 == 1)
+	}
+}
+
+// writeGolombRice writes the quotient of value / 2^p in unary and the remainder in p bits
+func (w *bitWriter) writeGolombRice(value uint64, p uint8) {
+	for q := value >> p; q > 0; q-- {
+		w.writeBit(true)
+	}
+	w.writeBit(false)
+	w.writeBits(value, p)
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.data
+}
+
+type bitReader struct {
+	data []byte
+	pos  uint64 // in bits
+}
+
+// readGolombRice reads the unary quotient a byte at a time, then the p bits of the remainder
+func (r *bitReader) readGolombRice(p uint8) (uint64, bool) {
+	q := uint64(0)
+	for {
+		if r.pos>>3 >= uint64(len(r.data)) {
+			return 0, false
+		}
+		offset := uint8(r.pos & 7)
+		available := 8 - offset
+		ones := min(uint8(bits.LeadingZeros8(^(r.data[r.pos>>3] << offset))), available)
+		q += uint64(ones)
+		r.pos += uint64(ones)
+		if ones < available {
+			r.pos++ // the terminating zero
+			break
+		}
+	}
+
+	remainder, ok := r.readBits(p)
+	return q<<p | remainder, ok
+}
+
+func (r *bitReader) readBits(n uint8) (uint64, bool) {
+	if r.pos+uint64(n) > uint64(len(r.data))*8 {
+		return 0, false
+	}
+	value := uint64(0)
+	for n > 0 {
+		offset := uint8(r.pos & 7)
+		available := 8 - offset
+		take := min(available, n)
+		chunk := (r.data[r.pos>>3] >> (available - take)) & (1<<take - 1)
+		value = value<<take | uint64(chunk)
+		r.pos += uint64(take)
+		n -= take
+	}
+	return value, true
+}
+
+func writeCompactSize(buf *bytes.Buffer, n uint64) {
+	switch {
+	case n < 0xfd:
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(0xfd)
+		filter.SerializeUint(buf, n, 2)
+	case n <= 0xffffffff:
+		buf.WriteByte(0xfe)
+		filter.SerializeUint(buf, n, 4)
+	default:
+		buf.WriteByte(0xff)
+		filter.SerializeUint(buf, n, 8)
+	}
+}
+
+func readCompactSize(buf *bytes.Buffer) (uint64, error) {
+	prefix, err := buf.ReadByte()
+	if err != nil {
+		return 0, ErrInvalidEncoding
+	}
+	size := map[byte]int{0xfd: 2, 0xfe: 4, 0xff: 8}[prefix]
+	if size == 0 {
+		return uint64(prefix), nil
+	}
+	if buf.Len() < size {
+		return 0, ErrInvalidEncoding
+	}
+	return filter.DeserializeUint[uint64](buf, size), nil
+}
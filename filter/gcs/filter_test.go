@@ -0,0 +1,231 @@
+package gcs_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter/gcs"
+)
+
+// blockHash converts a block hash as displayed by bitcoin nodes to internal byte order
+func blockHash(t *testing.T, display string) [32]byte {
+	decoded, err := hex.DecodeString(display)
+	if err != nil || len(decoded) != 32 {
+		t.Fatalf("invalid block hash %s", display)
+	}
+	var hash [32]byte
+	for i := range decoded {
+		hash[i] = decoded[31-i]
+	}
+	return hash
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %s", s)
+	}
+	return decoded
+}
+
+func generateItems(prefix string, n int) [][]byte {
+	items := make([][]byte, n)
+	for i := range items {
+		items[i] = []byte(fmt.Sprintf("%s_%d", prefix, i))
+	}
+	return items
+}
+
+// test vector of the testnet genesis block from bip-0158/testnet-19.json,
+// its only element is the output script of the coinbase transaction
+func TestBIP158GenesisVector(t *testing.T) {
+	hash := blockHash(t, "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943")
+	script := decodeHex(t, "4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")
+	expected := decodeHex(t, "019dfca8")
+
+	gf := gcs.NewBIP158Filter(hash, [][]byte{script})
+	if encoded := gf.BIP158Bytes(); !bytes.Equal(encoded, expected) {
+		t.Fatalf("expected filter %x, got %x", expected, encoded)
+	}
+
+	decoded, err := gcs.DeserializeBIP158(hash, expected)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded.N != 1 || decoded.P != gcs.BIP158P || decoded.M != gcs.BIP158M {
+		t.Errorf("unexpected parameters N=%d P=%d M=%d", decoded.N, decoded.P, decoded.M)
+	}
+	if !decoded.Match(script) {
+		t.Error("decoded filter should match the coinbase script")
+	}
+	if decoded.Match([]byte("not in the block")) {
+		t.Error("decoded filter should not match a random element")
+	}
+	if !decoded.MatchAny([][]byte{[]byte("not in the block"), script}) {
+		t.Error("decoded filter should match any of a list containing the coinbase script")
+	}
+}
+
+// the empty filter of bip-0158/testnet-19.json, block 1414221 ("Empty data"): only N = 0 is
+// encoded, whatever the key
+func TestBIP158EmptyVector(t *testing.T) {
+	hash := blockHash(t, "0000000000000027b2b3b3381f114f674f481544ff2be37ae3788d7e078383b1")
+	expected := decodeHex(t, "00")
+
+	if encoded := gcs.NewBIP158Filter(hash, nil).BIP158Bytes(); !bytes.Equal(encoded, expected) {
+		t.Fatalf("expected filter %x, got %x", expected, encoded)
+	}
+	decoded, err := gcs.DeserializeBIP158(hash, expected)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded.N != 0 || decoded.Match([]byte{}) || decoded.MatchAny([][]byte{{0x6a}}) {
+		t.Error("the empty filter should match nothing")
+	}
+}
+
+// filter of several output scripts, a duplicate included, keyed by the testnet genesis block.
+// The expected bytes come from an independent implementation of BIP158 that reproduces the
+// genesis vector above and the SipHash-2-4 reference vectors, so the sorted deltas, their
+// golomb-rice coding with P = 19 and the range N * M with M = 784931 are all pinned.
+func TestBIP158SeveralElements(t *testing.T) {
+	hash := blockHash(t, "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943")
+	scripts := [][]byte{
+		decodeHex(t, "76a914913bcc2be49cb534c20474c4dee1e9c4c317e7eb88ac"),
+		decodeHex(t, "a914feb8a29635c56d9cd913122f90678756bf23887687"),
+		decodeHex(t, "0014751e76e8199196d454941c45d1b3a323f1433bd6"),
+		decodeHex(t, "5121030000000000000000000000000000000000000000000000000000000000000001"),
+		decodeHex(t, "76a914c01a7ca16b47be50cbdbc60724f701d52d75156688ac"),
+		decodeHex(t, "76a914913bcc2be49cb534c20474c4dee1e9c4c317e7eb88ac"),
+	}
+	expected := decodeHex(t, "05cf3790dd3056cf6616ab78453900")
+
+	gf := gcs.NewBIP158Filter(hash, scripts)
+	if encoded := gf.BIP158Bytes(); !bytes.Equal(encoded, expected) {
+		t.Fatalf("expected filter %x, got %x", expected, encoded)
+	}
+	decoded, err := gcs.DeserializeBIP158(hash, expected)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded.N != 5 || decoded.P != gcs.BIP158P || decoded.M != gcs.BIP158M {
+		t.Errorf("unexpected parameters N=%d P=%d M=%d", decoded.N, decoded.P, decoded.M)
+	}
+	for _, script := range scripts {
+		if !decoded.Match(script) {
+			t.Errorf("decoded filter should match %x", script)
+		}
+	}
+}
+
+func TestBIP158Encoding(t *testing.T) {
+	var hash [32]byte
+	empty := gcs.NewBIP158Filter(hash, nil)
+	if encoded := empty.BIP158Bytes(); !bytes.Equal(encoded, []byte{0}) {
+		t.Errorf("expected empty filter 00, got %x", encoded)
+	}
+	if empty.Match([]byte("anything")) || empty.MatchAny([][]byte{[]byte("anything")}) {
+		t.Error("empty filter should not match")
+	}
+
+	// N >= 0xfd is written as 0xfd followed by a little endian uint16
+	items := generateItems("item", 300)
+	encoded := gcs.NewBIP158Filter(hash, items).BIP158Bytes()
+	if !bytes.Equal(encoded[:3], []byte{0xfd, 0x2c, 0x01}) {
+		t.Errorf("expected CompactSize fd2c01, got %x", encoded[:3])
+	}
+
+	decoded, err := gcs.DeserializeBIP158(hash, encoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, item := range items {
+		if !decoded.Match(item) {
+			t.Fatalf("false negative for %s", item)
+		}
+	}
+
+	if _, err := gcs.DeserializeBIP158(hash, []byte{0xfe, 0x01}); err != gcs.ErrInvalidEncoding {
+		t.Errorf("expected ErrInvalidEncoding, got %v", err)
+	}
+}
+
+func TestDuplicateItems(t *testing.T) {
+	items := generateItems("item", 100)
+	items = append(items, items[:50]...)
+	gf := gcs.NewGCSFilter(items, 19, 1<<19, [16]byte{1})
+	if gf.N != 100 {
+		t.Errorf("expected 100 distinct items, got %d", gf.N)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	n := 10000
+	p := uint8(10)
+	items := generateItems("item", n)
+	others := generateItems("other", 20000)
+	gf := gcs.NewGCSFilter(items, p, 1<<p, [16]byte{1, 2, 3})
+
+	for _, item := range items {
+		if !gf.Match(item) {
+			t.Fatalf("false negative for %s", item)
+		}
+	}
+
+	falsePositives := 0
+	for _, item := range others {
+		if gf.Match(item) {
+			falsePositives++
+		}
+	}
+	rate := float64(falsePositives) / float64(len(others))
+	expected := 1.0 / float64(uint64(1)<<p)
+	if rate > 1.5*expected {
+		t.Errorf("false positive rate too high: %f (expected ~%f)", rate, expected)
+	}
+
+	bitsPerItem := float64(len(gf.Data)*8) / float64(n)
+	if bitsPerItem > float64(p)+2 {
+		t.Errorf("expected at most %d bits per item, got %.2f", p+2, bitsPerItem)
+	}
+	t.Logf("false positive rate: %f, bits per item: %.2f", rate, bitsPerItem)
+}
+
+func TestMatchAny(t *testing.T) {
+	items := generateItems("item", 1000)
+	others := generateItems("other", 20)
+	gf := gcs.NewGCSFilter(items, 19, 1<<19, [16]byte{4, 5, 6})
+
+	if gf.MatchAny(others) {
+		t.Error("no item of the query list is in the set")
+	}
+	if !gf.MatchAny(append(others, items[999])) {
+		t.Error("the last item of the query list is in the set")
+	}
+	if !gf.MatchAny(append([][]byte{items[0]}, others...)) {
+		t.Error("the first item of the query list is in the set")
+	}
+	if gf.MatchAny(nil) {
+		t.Error("an empty query list should not match")
+	}
+}
+
+func TestSerializeDeserialize(t *testing.T) {
+	items := generateItems("item", 5000)
+	gf := gcs.NewGCSFilter(items, 16, 50000, [16]byte{7, 8, 9})
+
+	deserialized := gcs.Deserialize(gf.Serialize())
+	if gf.N != deserialized.N || gf.P != deserialized.P || gf.M != deserialized.M || gf.Key != deserialized.Key {
+		t.Fatalf("header mismatch: %+v != %+v", gf, deserialized)
+	}
+	if !bytes.Equal(gf.Data, deserialized.Data) {
+		t.Fatal("data mismatch")
+	}
+	for _, item := range items {
+		if !deserialized.Match(item) {
+			t.Fatalf("false negative for %s after deserialization", item)
+		}
+	}
+}
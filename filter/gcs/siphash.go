@@ -0,0 +1,58 @@
+package gcs
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// sipHash computes SipHash-2-4 of data keyed with k0|k1, it is the hash
+// function required by BIP158
+func sipHash(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	length := len(data)
+	for ; len(data) >= 8; data = data[8:] {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+
+	// the last block holds the remaining bytes and the length in its most significant byte
+	last := uint64(length) << 56
+	for i, b := range data {
+		last |= uint64(b) << (8 * i)
+	}
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,159 @@
+package filter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Handle serves a filter that is periodically rebuilt, e.g. a bloom filter of a set whose
+// keys get deleted: readers load the current filter with an atomic pointer and never wait,
+// Rebuild builds the next filter while the current one serves, then swaps them (RCU).
+// F must be safe for concurrent use when Insert is called concurrently with readers,
+// as ConcurrentBloomFilter or Sharded.
+type Handle[F DigestFilter] struct {
+	current atomic.Pointer[handleFilter[F]]
+	insert  func(f F) func(Digest) bool // the InsertDigest of a filter, chosen by the constructor
+
+	// inserts hold the read lock, the swap of Rebuild the write lock, so no insertion lands
+	// in the old filter after its replay
+	swapMu    sync.RWMutex
+	rebuildMu sync.Mutex // one rebuild at a time
+
+	logMu     sync.Mutex
+	recording bool
+	log       []Digest // keys inserted since the rebuild started
+}
+
+type handleFilter[F DigestFilter] struct {
+	f      F
+	insert func(Digest) bool
+}
+
+// NewHandle serves f, e.g. a Sharded cuckoo filter whose insertions can fail
+func NewHandle[F InsertDigestFilter](f F) *Handle[F] {
+	return newHandle(f, func(f F) func(Digest) bool { return f.InsertDigest })
+}
+
+// NewInfallibleHandle serves f, e.g. a ConcurrentBloomFilter, insertions always report success
+func NewInfallibleHandle[F InfallibleFilter](f F) *Handle[F] {
+	return newHandle(f, func(f F) func(Digest) bool { return Infallible(f).InsertDigest })
+}
+
+func newHandle[F DigestFilter](f F, insert func(F) func(Digest) bool) *Handle[F] {
+	h := &Handle[F]{insert: insert}
+	h.Swap(f)
+	return h
+}
+
+// Load returns the current filter, it stays valid after a swap but stops receiving insertions
+func (h *Handle[F]) Load() F {
+	return h.current.Load().f
+}
+
+// Swap makes f the current filter and returns the previous one, without any replay
+func (h *Handle[F]) Swap(f F) F {
+	h.swapMu.Lock()
+	defer h.swapMu.Unlock()
+	return h.swap(f)
+}
+
+func (h *Handle[F]) swap(f F) F {
+	old := h.current.Swap(&handleFilter[F]{f: f, insert: h.insert(f)})
+	if old == nil {
+		var zero F
+		return zero
+	}
+	return old.f
+}
+
+func (h *Handle[F]) Insert(data []byte) bool {
+	return h.InsertDigest(NewDigest(data))
+}
+
+func (h *Handle[F]) Exist(data []byte) bool {
+	return h.ExistDigest(NewDigest(data))
+}
+
+func (h *Handle[F]) InsertString(s string) bool {
+	return h.InsertDigest(NewDigestString(s))
+}
+
+func (h *Handle[F]) ExistString(s string) bool {
+	return h.ExistDigest(NewDigestString(s))
+}
+
+func (h *Handle[F]) InsertUint64(x uint64) bool {
+	return h.InsertDigest(NewDigestUint64(x))
+}
+
+func (h *Handle[F]) ExistUint64(x uint64) bool {
+	return h.ExistDigest(NewDigestUint64(x))
+}
+
+// InsertDigest inserts the key of d in the current filter, and records it for the replay
+// when a rebuild is running
+func (h *Handle[F]) InsertDigest(d Digest) bool {
+	h.swapMu.RLock()
+	defer h.swapMu.RUnlock()
+	inserted := h.current.Load().insert(d)
+	h.logMu.Lock()
+	if h.recording {
+		h.log = append(h.log, d)
+	}
+	h.logMu.Unlock()
+	return inserted
+}
+
+func (h *Handle[F]) ExistDigest(d Digest) bool {
+	return h.current.Load().f.ExistDigest(d)
+}
+
+// Rebuild calls build, typically to fill a new filter from the source of truth, while the
+// current filter keeps serving, then swaps the filters. With replay, the keys inserted through
+// the handle since Rebuild was called are inserted in the new filter before the swap, so the
+// insertions racing with a snapshot of the source of truth are not lost.
+// Nothing is swapped when build returns an error, or when ctx is cancelled.
+func (h *Handle[F]) Rebuild(ctx context.Context, replay bool, build func(ctx context.Context) (F, error)) error {
+	h.rebuildMu.Lock()
+	defer h.rebuildMu.Unlock()
+
+	h.setRecording(replay)
+	defer h.setRecording(false)
+
+	f, err := build(ctx)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	insert := h.insert(f)
+	if replay {
+		// replay most of the log while insertions go on, then the rest once they are blocked
+		h.logMu.Lock()
+		log := h.log
+		h.logMu.Unlock()
+		for _, d := range log {
+			insert(d)
+		}
+		h.swapMu.Lock()
+		defer h.swapMu.Unlock()
+		for _, d := range h.log[len(log):] {
+			insert(d)
+		}
+	} else {
+		h.swapMu.Lock()
+		defer h.swapMu.Unlock()
+	}
+	h.swap(f)
+	return nil
+}
+
+func (h *Handle[F]) setRecording(recording bool) {
+	h.logMu.Lock()
+	defer h.logMu.Unlock()
+	h.recording = recording
+	h.log = nil
+}
@@ -0,0 +1,131 @@
+package filter_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter"
+	"github.com/rag-nar1/Filters/filter/bloom"
+	"github.com/rag-nar1/Filters/filter/cuckoo"
+)
+
+func TestHandleRebuild(t *testing.T) {
+	const n = 20000
+	stable := make([]string, n) // in the source of truth during the whole test
+	for i := range stable {
+		stable[i] = fmt.Sprintf("stable_%d", i)
+	}
+	newFilter := func() *bloom.ConcurrentBloomFilter {
+		f := bloom.NewConcurrentBloomFilter(3*n, 0.001)
+		for _, key := range stable {
+			f.InsertString(key)
+		}
+		return f
+	}
+
+	for _, replay := range []bool{true, false} {
+		h := filter.NewInfallibleHandle(newFilter())
+
+		// readers never miss a stable key, whatever filter they are served
+		stop := make(chan struct{})
+		var readers sync.WaitGroup
+		var misses atomic.Int64
+		for r := 0; r < 4; r++ {
+			readers.Add(1)
+			go func(r int) {
+				defer readers.Done()
+				for i := r; ; i += 4 {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					if !h.ExistString(stable[i%n]) {
+						misses.Add(1)
+					}
+				}
+			}(r)
+		}
+
+		// keys inserted while the new filter is built from a snapshot of the source of truth
+		building, built := make(chan struct{}), make(chan struct{})
+		var rebuildErr error
+		go func() {
+			rebuildErr = h.Rebuild(context.Background(), replay, func(context.Context) (*bloom.ConcurrentBloomFilter, error) {
+				f := newFilter()
+				close(building)
+				<-built
+				return f, nil
+			})
+			close(stop)
+		}()
+		<-building
+		for i := 0; i < n; i++ {
+			h.InsertString(fmt.Sprintf("new_%d", i))
+		}
+		close(built)
+		readers.Wait()
+
+		if rebuildErr != nil {
+			t.Fatal(rebuildErr)
+		}
+		if misses.Load() != 0 {
+			t.Fatalf("replay=%v: %d stable keys missed during the rebuild", replay, misses.Load())
+		}
+		found := 0
+		for i := 0; i < n; i++ {
+			if h.ExistString(fmt.Sprintf("new_%d", i)) {
+				found++
+			}
+		}
+		if replay && found != n {
+			t.Fatalf("replay lost %d keys inserted during the rebuild", n-found)
+		}
+		if !replay && found > n/10 {
+			t.Fatalf("without replay, expected the keys inserted during the rebuild to be dropped, %d found", found)
+		}
+	}
+}
+
+func TestHandleRebuildError(t *testing.T) {
+	f := bloom.NewConcurrentBloomFilter(1000, 0.01)
+	h := filter.NewInfallibleHandle(f)
+	errBuild := errors.New("source unavailable")
+	err := h.Rebuild(context.Background(), true, func(context.Context) (*bloom.ConcurrentBloomFilter, error) {
+		return nil, errBuild
+	})
+	if !errors.Is(err, errBuild) || h.Load() != f {
+		t.Fatalf("a failed rebuild must keep the current filter, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = h.Rebuild(ctx, false, func(context.Context) (*bloom.ConcurrentBloomFilter, error) {
+		return bloom.NewConcurrentBloomFilter(1000, 0.01), nil
+	})
+	if !errors.Is(err, context.Canceled) || h.Load() != f {
+		t.Fatalf("a cancelled rebuild must keep the current filter, got %v", err)
+	}
+
+	next := bloom.NewConcurrentBloomFilter(1000, 0.01)
+	if old := h.Swap(next); old != f || h.Load() != next {
+		t.Fatal("Swap must return the previous filter and serve the new one")
+	}
+}
+
+func TestHandleInsertFailure(t *testing.T) {
+	h := filter.NewHandle(cuckoo.NewCuckooFilter(100, 0.95))
+	inserted := 0
+	for i := uint64(0); i < 1000; i++ {
+		if h.InsertUint64(i) {
+			inserted++
+		}
+	}
+	if inserted == 0 || inserted == 1000 {
+		t.Fatalf("expected the insertions into a full cuckoo filter to fail, %d of 1000 inserted", inserted)
+	}
+}
@@ -2,13 +2,80 @@ package filter
 
 type Hash func(data []byte, m uint64, k uint32) []int
 
+// HashScheme selects how the k probes of a key are derived from the two halves of its hash
+type HashScheme uint8
+
+const (
+	// DoubleHashing probes h1 + i*h2, the probes of a key collapse when i*h2 wraps around
+	// to the same index, it is kept to read filters serialized before EnhancedDoubleHashing
+	DoubleHashing HashScheme = iota
+	// EnhancedDoubleHashing probes h1 + i*h2 + (i^3 - i)/6 ("Bloom Filters in Probabilistic
+	// Verification", Dillinger & Manolios), the cubic term breaks the cycles of double hashing
+	EnhancedDoubleHashing
+)
+
+// Probes iterates the probes of a hash in a HashScheme, the zero value is not usable.
+type Probes struct {
+	x, y      uint32
+	i         uint32
+	increment uint32 // 1 for enhanced double hashing, y then grows by i at step i, from 1
+}
+
+func NewProbes(hash uint64, scheme HashScheme) Probes {
+	p := Probes{x: uint32(hash), y: uint32(hash >> 32)}
+	if scheme == EnhancedDoubleHashing {
+		p.increment = 1
+	}
+	return p
+}
+
+// Next returns the next probe, it still has to be reduced to the size of the filter
+func (p *Probes) Next() uint32 {
+	probe := p.x
+	p.x += p.y
+	p.i++
+	p.y += p.i * p.increment
+	return probe
+}
+
 func DoubleHash(hash uint64, m uint32, k uint32) []int {
-	hashedIdx := make([]int, k)
-	h1 := uint32(hash)
-	h2 := uint32(hash >> 32)
+	return AppendDoubleHash(make([]int, 0, k), hash, m, k)
+}
+
+// AppendDoubleHash appends the k indexes h1 + i*h2 reduced to [0, m) to dst and returns
+// the extended slice, like the strconv Append functions it only allocates when dst is too small.
+func AppendDoubleHash(dst []int, hash uint64, m uint32, k uint32) []int {
+	return AppendProbes(dst, hash, m, k, DoubleHashing)
+}
 
+// AppendProbes appends the k indexes of the scheme reduced to [0, m) to dst
+func AppendProbes(dst []int, hash uint64, m uint32, k uint32, scheme HashScheme) []int {
+	probes := NewProbes(hash, scheme)
 	for i := uint32(0); i < k; i++ {
-		hashedIdx[i] = int((h1 + uint32(i)*h2) & (m - 1))
+		dst = append(dst, int(Reduce(probes.Next(), m)))
 	}
-	return hashedIdx
+	return dst
+}
+
+// Mix64 is the murmur3 64-bit finalizer, it spreads the entropy of x over all 64 bits.
+func Mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
 }
+
+// Reduce maps x to [0, n): with a mask when n is a power of two, otherwise with Lemire's
+// multiply and shift, which keeps the most significant bits of x and avoids a modulo.
+func Reduce(x, n uint32) uint32 {
+	if n&(n-1) == 0 {
+		return x & (n - 1)
+	}
+	return uint32((uint64(x) * uint64(n)) >> 32)
+}
+
+// BatchWindow is the number of keys batch operations hash before probing the filter, their
+// independent memory accesses are then issued back to back and overlap their cache misses
+const BatchWindow = 16
@@ -0,0 +1,242 @@
+package filter_test
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter"
+	blockedbloom "github.com/rag-nar1/Filters/filter/blocked-bloom"
+	"github.com/rag-nar1/Filters/filter/bloom"
+	"github.com/rag-nar1/Filters/filter/cuckoo"
+	"github.com/rag-nar1/Filters/filter/gcs"
+	"github.com/rag-nar1/Filters/filter/quotient"
+	"github.com/rag-nar1/Filters/filter/ribbon"
+	"github.com/rag-nar1/Filters/filter/vacuum"
+	"github.com/rag-nar1/Filters/filter/xorfilter"
+)
+
+// hasher is implemented by every filter to expose the positions probed for a key
+type hasher interface {
+	AppendHash(dst []int, data []byte) []int
+}
+
+func hashers(b testing.TB) map[string]hasher {
+	const n = 10000
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key_%d", i))
+	}
+
+	xf := &xorfilter.Xor8{}
+	bf := &xorfilter.BinaryFuse8{}
+	rf := ribbon.NewRibbonFilter(0.01)
+	for _, populate := range []func([][]byte) error{xf.Populate, bf.Populate, rf.Populate} {
+		if err := populate(keys); err != nil {
+			b.Fatalf("construction failed: %v", err)
+		}
+	}
+	return map[string]hasher{
+		"Bloom":        bloom.NewBloomFilter(n, 0.01),
+		"BlockedBloom": blockedbloom.NewBlockedBloomFilter(n, 0.01),
+		"SplitBlock":   blockedbloom.NewSplitBlockFilter(n, 0.01),
+		"Cuckoo":       cuckoo.NewCuckooFilter(n, 0.95),
+		"Vacuum":       vacuum.NewVacuumFilter(n, 0.95),
+		"Quotient":     quotient.NewQuotientFilter(n, 0.01),
+		"Xor":          xf,
+		"BinaryFuse":   bf,
+		"Ribbon":       rf,
+		"GCS":          gcs.NewGCSFilter(keys, 19, 1<<19, [16]byte{1}),
+	}
+}
+
+func TestAppendDoubleHash(t *testing.T) {
+	for _, m := range []uint32{1 << 20, 1000003} {
+		hash := uint64(0x9e3779b97f4a7c15)
+		expected := filter.DoubleHash(hash, m, 7)
+		buf := make([]int, 0, 7)
+		if got := filter.AppendDoubleHash(buf[:0], hash, m, 7); !slices.Equal(got, expected) {
+			t.Errorf("m=%d: expected %v, got %v", m, expected, got)
+		}
+		for _, idx := range expected {
+			if idx < 0 || idx >= int(m) {
+				t.Errorf("m=%d: index %d out of range", m, idx)
+			}
+		}
+	}
+
+	bf := bloom.NewBloomFilter(1000, 0.01)
+	data := []byte("append hash")
+	if !slices.Equal(bf.Hash(data), bf.AppendHash(nil, data)) {
+		t.Error("Hash and AppendHash disagree")
+	}
+}
+
+// TestProbes pins the probes of both schemes: h1 + i*h2 and h1 + i*h2 + (i^3 - i)/6, with
+// h1 the low and h2 the high half of the hash. With h2 = 0 the enhanced probes still differ
+// from the third one on, and the first ones wrap around 2^32.
+func TestProbes(t *testing.T) {
+	tests := []struct {
+		name   string
+		hash   uint64
+		scheme filter.HashScheme
+		want   []uint32
+	}{
+		{"double", 7<<32 | 100, filter.DoubleHashing, []uint32{100, 107, 114, 121, 128, 135}},
+		{"double h2=0", 100, filter.DoubleHashing, []uint32{100, 100, 100, 100, 100, 100}},
+		{"enhanced", 7<<32 | 100, filter.EnhancedDoubleHashing, []uint32{100, 107, 115, 125, 138, 155}},
+		{"enhanced h2=0", 100, filter.EnhancedDoubleHashing, []uint32{100, 100, 101, 104, 110, 120}},
+		{"enhanced wraps", 0xffffffff<<32 | 0xfffffffe, filter.EnhancedDoubleHashing,
+			[]uint32{0xfffffffe, 0xfffffffd, 0xfffffffd, 0xffffffff, 0x4, 0xd}},
+	}
+	for _, tt := range tests {
+		probes := filter.NewProbes(tt.hash, tt.scheme)
+		got := make([]uint32, len(tt.want))
+		for i := range got {
+			got[i] = probes.Next()
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: probes %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkAppendHash asserts that reusing the buffer makes AppendHash allocation free for every filter
+func BenchmarkAppendHash(b *testing.B) {
+	data := []byte("probe positions of this key")
+	for name, h := range hashers(b) {
+		b.Run(name, func(b *testing.B) {
+			buf := make([]int, 0, 64)
+			if allocs := testing.AllocsPerRun(100, func() { buf = h.AppendHash(buf[:0], data) }); allocs != 0 {
+				b.Fatalf("expected 0 allocs/op, got %v", allocs)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf = h.AppendHash(buf[:0], data)
+			}
+		})
+	}
+}
+
+// sink keeps the results of DoubleHash on the heap, as callers storing them would
+var sink []int
+
+func BenchmarkDoubleHash(b *testing.B) {
+	b.Run("DoubleHash", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sink = filter.DoubleHash(uint64(i), 1<<20, 7)
+		}
+	})
+	b.Run("AppendDoubleHash", func(b *testing.B) {
+		buf := make([]int, 0, 7)
+		if allocs := testing.AllocsPerRun(100, func() { buf = filter.AppendDoubleHash(buf[:0], 42, 1<<20, 7) }); allocs != 0 {
+			b.Fatalf("expected 0 allocs/op, got %v", allocs)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			buf = filter.AppendDoubleHash(buf[:0], uint64(i), 1<<20, 7)
+		}
+	})
+}
+
+// digester is implemented by the filters probed from a precomputed filter.Digest
+type digester interface {
+	InsertDigest(d filter.Digest)
+	ExistDigest(d filter.Digest) bool
+}
+
+type cuckooDigester struct{ *cuckoo.CuckooFilter }
+
+func (c cuckooDigester) InsertDigest(d filter.Digest) { c.CuckooFilter.InsertDigest(d) }
+
+func TestDigest(t *testing.T) {
+	const n = 10000
+	// filters of different sizes and seeds all probed from the same digests
+	filters := map[string]digester{
+		"Bloom":             bloom.NewBloomFilter(n, 0.01),
+		"BloomExact":        bloom.NewBloomFilterExact(3*n, 0.001),
+		"BlockedBloom":      blockedbloom.NewBlockedBloomFilter(n, 0.01),
+		"BlockedBloomExact": blockedbloom.NewBlockedBloomFilterExact(2*n, 0.0001),
+		"Cuckoo":            cuckooDigester{cuckoo.NewCuckooFilter(n, 0.9)},
+		"CuckooExact":       cuckooDigester{cuckoo.NewCuckooFilterExact(n, 0.9)},
+	}
+	lookups := map[string]func([]byte) bool{}
+	for name, f := range filters {
+		switch f := f.(type) {
+		case *bloom.BloomFilter:
+			lookups[name] = f.Exist
+		case *blockedbloom.BlockedBloomFilter:
+			lookups[name] = f.Exist
+		case cuckooDigester:
+			lookups[name] = f.Lookup
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		d := filter.NewDigest([]byte(key))
+		if filter.NewDigestString(key) != d {
+			t.Fatalf("NewDigestString and NewDigest disagree on %s", key)
+		}
+		// both halves come from the key, no mixer of Lo gives Hi
+		if d.Hi == d.Lo || d.Hi == filter.Mix64(d.Lo+0x9e3779b97f4a7c15) {
+			t.Fatalf("Hi of %s is derived from Lo", key)
+		}
+		for _, f := range filters {
+			f.InsertDigest(d)
+		}
+	}
+	for i := 0; i < 2*n; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		d := filter.NewDigest(key)
+		for name, f := range filters {
+			if f.ExistDigest(d) != lookups[name](key) {
+				t.Fatalf("%s: ExistDigest and Exist disagree on %s", name, key)
+			}
+			if i < n && !f.ExistDigest(d) {
+				t.Fatalf("%s: false negative for %s", name, key)
+			}
+		}
+	}
+}
+
+// BenchmarkDigest compares hashing a key once for several filters with hashing it for each of them
+func BenchmarkDigest(b *testing.B) {
+	const n = 1 << 20
+	filters := []digester{
+		bloom.NewBloomFilter(n, 0.01),
+		bloom.NewBloomFilter(n/4, 0.001),
+		blockedbloom.NewBlockedBloomFilter(n, 0.01),
+		blockedbloom.NewBlockedBloomFilter(n/4, 0.001),
+	}
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("a somewhat longer key to hash, number %d", i))
+	}
+	exists := []func([]byte) bool{
+		filters[0].(*bloom.BloomFilter).Exist,
+		filters[1].(*bloom.BloomFilter).Exist,
+		filters[2].(*blockedbloom.BlockedBloomFilter).Exist,
+		filters[3].(*blockedbloom.BlockedBloomFilter).Exist,
+	}
+
+	b.Run("Exist", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			key := keys[i&(len(keys)-1)]
+			for _, exist := range exists {
+				exist(key)
+			}
+		}
+	})
+	b.Run("ExistDigest", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			d := filter.NewDigest(keys[i&(len(keys)-1)])
+			for _, f := range filters {
+				f.ExistDigest(d)
+			}
+		}
+	})
+}
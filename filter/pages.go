@@ -0,0 +1,117 @@
+package filter
+
+import "sync/atomic"
+
+// PageShift sets the size of the pages of a Pages to 1 << PageShift words, 8KB of uint64
+// or 4KB of uint32
+const (
+	PageShift = 10
+	PageWords = 1 << PageShift
+)
+
+// pagesEpoch hands out the epochs of Pages, every Pages owns the pages of its own epoch only
+var pagesEpoch atomic.Uint64
+
+// Pages is an array of n words split into pages shared copy-on-write with its snapshots:
+// Snapshot copies nothing, the first write to a shared page copies it, and the first write
+// after a snapshot copies the page table, 8 bytes per page. Writes leaving a word unchanged
+// copy nothing, as the bits already set by most insertions into a bloom filter.
+// A Pages is not safe for concurrent writes, but a snapshot never changes once taken, it can
+// be read or serialized by other goroutines while the Pages it was taken from is written.
+type Pages[T uint32 | uint64] struct {
+	n      int
+	table  []*page[T]
+	shared bool   // the table is shared with a snapshot, it is copied before its first modification
+	epoch  uint64 // the pages of an other epoch are shared, they are copied before being written
+}
+
+type page[T uint32 | uint64] struct {
+	epoch uint64
+	words [PageWords]T
+}
+
+// NewPages returns n zeroed words, allocated at once
+func NewPages[T uint32 | uint64](n int) *Pages[T] {
+	p := &Pages[T]{n: n, epoch: pagesEpoch.Add(1)}
+	pages := make([]page[T], (n+PageWords-1)>>PageShift)
+	p.table = make([]*page[T], len(pages))
+	for i := range pages {
+		pages[i].epoch = p.epoch
+		p.table[i] = &pages[i]
+	}
+	return p
+}
+
+// PagesFromSlice returns Pages holding a copy of words
+func PagesFromSlice[T uint32 | uint64](words []T) *Pages[T] {
+	p := NewPages[T](len(words))
+	for i, pg := range p.table {
+		copy(pg.words[:], words[i<<PageShift:])
+	}
+	return p
+}
+
+func (p *Pages[T]) Len() int {
+	return p.n
+}
+
+func (p *Pages[T]) Get(i int) T {
+	return p.table[i>>PageShift].words[i&(PageWords-1)]
+}
+
+func (p *Pages[T]) Set(i int, value T) {
+	if p.Get(i) != value {
+		p.writable(i >> PageShift).words[i&(PageWords-1)] = value
+	}
+}
+
+// Or sets the bits of mask in word i
+func (p *Pages[T]) Or(i int, mask T) {
+	if p.Get(i)&mask != mask {
+		p.writable(i >> PageShift).words[i&(PageWords-1)] |= mask
+	}
+}
+
+// writable returns page i, copied first when it is shared with a snapshot
+func (p *Pages[T]) writable(i int) *page[T] {
+	pg := p.table[i]
+	if pg.epoch == p.epoch {
+		return pg
+	}
+	if p.shared {
+		p.table = append([]*page[T](nil), p.table...)
+		p.shared = false
+	}
+	copied := &page[T]{epoch: p.epoch, words: pg.words}
+	p.table[i] = copied
+	return copied
+}
+
+// Snapshot returns a copy of the words in constant time, both share every page until one of
+// them writes it. The snapshot can be written too, it then copies the pages it writes.
+func (p *Pages[T]) Snapshot() *Pages[T] {
+	p.shared = true
+	p.epoch = pagesEpoch.Add(1)
+	return &Pages[T]{n: p.n, table: p.table, shared: true, epoch: pagesEpoch.Add(1)}
+}
+
+// SharedPages returns the number of pages not copied since the last snapshot involving p
+func (p *Pages[T]) SharedPages() int {
+	shared := 0
+	for _, pg := range p.table {
+		if pg.epoch != p.epoch {
+			shared++
+		}
+	}
+	return shared
+}
+
+// Range calls yield with the words of every page in order, the last one is cut to Len, and
+// stops as soon as yield returns false. The slices must not be modified.
+func (p *Pages[T]) Range(yield func(words []T) bool) {
+	for i, pg := range p.table {
+		if !yield(pg.words[:min(PageWords, p.n-i<<PageShift)]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter"
+)
+
+func TestPagesSnapshot(t *testing.T) {
+	n := 10*filter.PageWords + 7
+	p := filter.NewPages[uint64](n)
+	for i := 0; i < n; i++ {
+		p.Set(i, uint64(i))
+	}
+
+	snapshot := p.Snapshot()
+	if p.SharedPages() != 11 || snapshot.SharedPages() != 11 {
+		t.Fatalf("a snapshot must share every page, %d and %d shared", p.SharedPages(), snapshot.SharedPages())
+	}
+
+	// writes leaving a word unchanged copy nothing, the others copy only their page
+	p.Or(0, 0)
+	p.Set(1, 1)
+	if p.SharedPages() != 11 {
+		t.Fatal("a write leaving the word unchanged copied its page")
+	}
+	p.Set(3*filter.PageWords, 0)
+	p.Or(n-1, 1<<63)
+	if p.SharedPages() != 9 || snapshot.SharedPages() != 11 {
+		t.Fatalf("expected the 2 written pages to be copied, %d shared", p.SharedPages())
+	}
+
+	// the snapshot is writable too, and both keep their own words
+	snapshot.Set(5, 0)
+	for i := 0; i < n; i++ {
+		want := uint64(i)
+		if got := snapshot.Get(i); i != 5 && got != want {
+			t.Fatalf("snapshot word %d is %d, want %d", i, got, want)
+		}
+		switch i {
+		case 3 * filter.PageWords:
+			want = 0
+		case n - 1:
+			want |= 1 << 63
+		}
+		if got := p.Get(i); got != want {
+			t.Fatalf("word %d is %d, want %d", i, got, want)
+		}
+	}
+	if snapshot.Get(5) != 0 || p.Get(5) != 5 {
+		t.Fatal("a write to the snapshot changed the filter")
+	}
+
+	words := 0
+	snapshot.Range(func(page []uint64) bool {
+		words += len(page)
+		return true
+	})
+	if words != n {
+		t.Fatalf("Range went over %d words, want %d", words, n)
+	}
+}
@@ -0,0 +1,441 @@
+package quotient
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
+
+	"github.com/rag-nar1/Filters/filter"
+	"github.com/zeebo/xxh3"
+)
+
+// the filter keeps at least one slot empty so every cluster has an end,
+// LoadFactor is the fill ratio used when sizing and merging filters
+const (
+	LoadFactor = 0.9
+	MaxBits    = 64 // Q + R can not exceed the 64 bits of the hash
+)
+
+var (
+	ErrIncompatible = errors.New("quotient: filters have different seeds or fingerprint sizes")
+	ErrCannotResize = errors.New("quotient: remainder is too small to resize the filter")
+	ErrFull         = errors.New("quotient: filter is full")
+)
+
+// QuotientFilter stores a (Q+R)-bit fingerprint of every key, the Q most significant
+// bits select the canonical slot and the R least significant bits are stored in it.
+// every slot has three metadata bits:
+// occupied: a key has this slot as its canonical slot
+// continuation: the slot continues the run of the previous slot
+// shifted: the remainder in the slot is not in its canonical slot
+type QuotientFilter struct {
+	Q       uint32 // number of quotient bits, the filter has 1<<Q slots
+	R       uint32 // number of remainder bits stored per slot
+	Seed    uint64
+	Entries uint64 // number of stored fingerprints, duplicates included
+
+	Occupied     []uint64
+	Continuation []uint64
+	Shifted      []uint64
+	Remainders   []uint64 // R bits per slot, packed
+}
+
+func NewQuotientFilter(n uint64, fpRate float64) *QuotientFilter {
+	// fpRate ~= LoadFactor * 2^-r
+	r := uint32(math.Ceil(math.Log2(1 / fpRate)))
+	q := uint32(math.Ceil(math.Log2(float64(max(n, 1)) / LoadFactor)))
+	r = max(r, 1)
+	q = max(q, 1)
+	if q+r > MaxBits {
+		r = MaxBits - q
+	}
+	return newQuotientFilter(q, r, rand.Uint64())
+}
+
+func newQuotientFilter(q, r uint32, seed uint64) *QuotientFilter {
+	size := uint64(1) << q
+	return &QuotientFilter{
+		Q:            q,
+		R:            r,
+		Seed:         seed,
+		Occupied:     make([]uint64, size>>6+1),
+		Continuation: make([]uint64, size>>6+1),
+		Shifted:      make([]uint64, size>>6+1),
+		Remainders:   make([]uint64, (size*uint64(r))>>6+2),
+	}
+}
+
+// Capacity returns the number of fingerprints the filter holds before Insert fails.
+func (qf *QuotientFilter) Capacity() uint64 {
+	return qf.size() - 1
+}
+
+func (qf *QuotientFilter) Insert(data []byte) bool {
+	fq, fr := qf.Hash(data)
+	return qf.insertFingerprint(fq, fr)
+}
+
+func (qf *QuotientFilter) Exist(data []byte) bool {
+	fq, fr := qf.Hash(data)
+	return qf.count(fq, fr, true) > 0
+}
+
+// Count returns the number of times the fingerprint of data was inserted.
+func (qf *QuotientFilter) Count(data []byte) uint64 {
+	fq, fr := qf.Hash(data)
+	return qf.count(fq, fr, false)
+}
+
+// Delete removes one copy of the fingerprint of data.
+func (qf *QuotientFilter) Delete(data []byte) bool {
+	fq, fr := qf.Hash(data)
+	if !getBit(qf.Occupied, fq) {
+		return false
+	}
+
+	start := qf.clusterStart(fq)
+	entries := qf.decode(start)
+	removed := -1
+	stillOccupied := false
+	for i, e := range entries {
+		if e.quotient != fq {
+			continue
+		}
+		if removed == -1 && e.remainder == fr {
+			removed = i
+		} else {
+			stillOccupied = true
+		}
+	}
+	if removed == -1 {
+		return false
+	}
+
+	oldLength := len(entries)
+	entries = append(entries[:removed], entries[removed+1:]...)
+	if !stillOccupied {
+		clearBit(qf.Occupied, fq)
+	}
+	qf.rewrite(start, entries, oldLength)
+	qf.Entries--
+	return true
+}
+
+// Resize doubles the number of slots in place by moving one bit of every
+// fingerprint from the remainder to the quotient, the keys are not rehashed.
+func (qf *QuotientFilter) Resize() error {
+	if qf.R <= 1 {
+		return ErrCannotResize
+	}
+	resized := newQuotientFilter(qf.Q+1, qf.R-1, qf.Seed)
+	rmask := uint64(1)<<resized.R - 1
+	qf.each(func(fq, fr uint64) {
+		fingerprint := fq<<qf.R | fr
+		resized.insertFingerprint(fingerprint>>resized.R, fingerprint&rmask)
+	})
+	*qf = *resized
+	return nil
+}
+
+// Merge inserts every fingerprint of other into qf, both filters must share the
+// seed and the fingerprint size, qf is resized when it can not hold both.
+func (qf *QuotientFilter) Merge(other *QuotientFilter) error {
+	if qf.Seed != other.Seed || qf.Q+qf.R != other.Q+other.R {
+		return ErrIncompatible
+	}
+	if other == qf {
+		// other is read while qf shifts its slots
+		clone := *qf
+		clone.Occupied = slices.Clone(qf.Occupied)
+		clone.Continuation = slices.Clone(qf.Continuation)
+		clone.Shifted = slices.Clone(qf.Shifted)
+		clone.Remainders = slices.Clone(qf.Remainders)
+		other = &clone
+	}
+	for float64(qf.Entries+other.Entries) > float64(qf.size())*LoadFactor {
+		if err := qf.Resize(); err != nil {
+			return err
+		}
+	}
+	if qf.Entries+other.Entries > qf.Capacity() {
+		return ErrFull
+	}
+
+	rmask := uint64(1)<<qf.R - 1
+	other.each(func(fq, fr uint64) {
+		fingerprint := fq<<other.R | fr
+		qf.insertFingerprint(fingerprint>>qf.R, fingerprint&rmask)
+	})
+	return nil
+}
+
+// Hash returns the quotient and the remainder of the fingerprint of data
+func (qf *QuotientFilter) Hash(data []byte) (uint64, uint64) {
+	hash := filter.Mix64(xxh3.Hash(data) ^ qf.Seed)
+	return qf.split(hash)
+}
+
+// AppendHash appends the canonical slot of data, its quotient, to dst,
+// the remainder is stored in the run of that slot
+func (qf *QuotientFilter) AppendHash(dst []int, data []byte) []int {
+	fq, _ := qf.Hash(data)
+	return append(dst, int(fq))
+}
+
+func (qf *QuotientFilter) split(hash uint64) (uint64, uint64) {
+	if qf.Q+qf.R < MaxBits {
+		hash &= uint64(1)<<(qf.Q+qf.R) - 1
+	}
+	return hash >> qf.R, hash & (uint64(1)<<qf.R - 1)
+}
+
+func (qf *QuotientFilter) insertFingerprint(fq, fr uint64) bool {
+	if qf.Entries >= qf.Capacity() {
+		return false
+	}
+	qf.Entries++
+
+	if qf.isEmpty(fq) {
+		setBit(qf.Occupied, fq)
+		qf.setRemainder(fq, fr)
+		return true
+	}
+
+	start := qf.clusterStart(fq)
+	entries := qf.decode(start)
+	oldLength := len(entries)
+
+	// place the new remainder at the end of the run of fq
+	dq := qf.distance(start, fq)
+	pos := len(entries)
+	for i, e := range entries {
+		if qf.distance(start, e.quotient) > dq {
+			pos = i
+			break
+		}
+	}
+	entries = append(entries, entry{})
+	copy(entries[pos+1:], entries[pos:])
+	entries[pos] = entry{quotient: fq, remainder: fr}
+
+	setBit(qf.Occupied, fq)
+	qf.rewrite(start, entries, oldLength)
+	return true
+}
+
+// count walks the run of fq and counts the slots holding fr
+func (qf *QuotientFilter) count(fq, fr uint64, first bool) uint64 {
+	if !getBit(qf.Occupied, fq) {
+		return 0
+	}
+
+	b := qf.clusterStart(fq)
+	s := b
+	for b != fq {
+		// skip the run of b
+		s = qf.next(s)
+		for getBit(qf.Continuation, s) {
+			s = qf.next(s)
+		}
+		// move b to the next occupied canonical slot
+		b = qf.next(b)
+		for !getBit(qf.Occupied, b) {
+			b = qf.next(b)
+		}
+	}
+
+	count := uint64(0)
+	for {
+		if qf.remainder(s) == fr {
+			count++
+			if first {
+				return count
+			}
+		}
+		s = qf.next(s)
+		if !getBit(qf.Continuation, s) {
+			return count
+		}
+	}
+}
+
+type entry struct {
+	quotient  uint64
+	remainder uint64
+}
+
+// clusterStart returns the first slot of the cluster containing slot i
+func (qf *QuotientFilter) clusterStart(i uint64) uint64 {
+	for getBit(qf.Shifted, i) {
+		i = qf.prev(i)
+	}
+	return i
+}
+
+// decode returns the entries stored from the cluster start until the next empty slot
+func (qf *QuotientFilter) decode(start uint64) []entry {
+	var entries []entry
+	var quotients []uint64 // canonical slots whose run was not reached yet
+	current := uint64(0)
+	for i := start; !qf.isEmpty(i); i = qf.next(i) {
+		if getBit(qf.Occupied, i) {
+			quotients = append(quotients, i)
+		}
+		if !getBit(qf.Continuation, i) {
+			current = quotients[0]
+			quotients = quotients[1:]
+		}
+		entries = append(entries, entry{quotient: current, remainder: qf.remainder(i)})
+	}
+	return entries
+}
+
+// rewrite lays out entries, sorted by quotient, from the cluster start that
+// previously held oldLength entries, occupied bits are maintained by the caller
+func (qf *QuotientFilter) rewrite(start uint64, entries []entry, oldLength int) {
+	for i, s := 0, start; i < max(oldLength, len(entries)); i, s = i+1, qf.next(s) {
+		clearBit(qf.Continuation, s)
+		clearBit(qf.Shifted, s)
+		qf.setRemainder(s, 0)
+	}
+
+	offset := uint64(0)
+	for i, e := range entries {
+		dq := qf.distance(start, e.quotient)
+		offset = max(offset, dq)
+		s := (start + offset) & qf.mask()
+		qf.setRemainder(s, e.remainder)
+		if i > 0 && entries[i-1].quotient == e.quotient {
+			setBit(qf.Continuation, s)
+		}
+		if offset != dq {
+			setBit(qf.Shifted, s)
+		}
+		offset++
+	}
+}
+
+// each calls fn with the quotient and remainder of every stored fingerprint
+func (qf *QuotientFilter) each(fn func(fq, fr uint64)) {
+	if qf.Entries == 0 {
+		return
+	}
+	start := uint64(0)
+	for !qf.isEmpty(start) {
+		start++
+	}
+
+	var quotients []uint64
+	current := uint64(0)
+	for n, i := uint64(0), qf.next(start); n < qf.size(); n, i = n+1, qf.next(i) {
+		if qf.isEmpty(i) {
+			continue
+		}
+		if getBit(qf.Occupied, i) {
+			quotients = append(quotients, i)
+		}
+		if !getBit(qf.Continuation, i) {
+			current = quotients[0]
+			quotients = quotients[1:]
+		}
+		fn(current, qf.remainder(i))
+	}
+}
+
+func (qf *QuotientFilter) size() uint64 {
+	return uint64(1) << qf.Q
+}
+
+func (qf *QuotientFilter) mask() uint64 {
+	return qf.size() - 1
+}
+
+func (qf *QuotientFilter) next(i uint64) uint64 {
+	return (i + 1) & qf.mask()
+}
+
+func (qf *QuotientFilter) prev(i uint64) uint64 {
+	return (i - 1) & qf.mask()
+}
+
+// distance returns how far slot i is after slot start, wrapping around the table
+func (qf *QuotientFilter) distance(start, i uint64) uint64 {
+	return (i - start) & qf.mask()
+}
+
+func (qf *QuotientFilter) isEmpty(i uint64) bool {
+	return !getBit(qf.Occupied, i) && !getBit(qf.Continuation, i) && !getBit(qf.Shifted, i)
+}
+
+func (qf *QuotientFilter) remainder(i uint64) uint64 {
+	offset := i * uint64(qf.R)
+	pos, shift := offset>>6, offset&63
+	value := qf.Remainders[pos] >> shift
+	if shift+uint64(qf.R) > 64 {
+		value |= qf.Remainders[pos+1] << (64 - shift)
+	}
+	if qf.R == 64 {
+		return value
+	}
+	return value & (uint64(1)<<qf.R - 1)
+}
+
+func (qf *QuotientFilter) setRemainder(i, r uint64) {
+	offset := i * uint64(qf.R)
+	pos, shift := offset>>6, offset&63
+	mask := ^uint64(0)
+	if qf.R < 64 {
+		mask = uint64(1)<<qf.R - 1
+	}
+	qf.Remainders[pos] = qf.Remainders[pos]&^(mask<<shift) | r<<shift
+	if shift+uint64(qf.R) > 64 {
+		qf.Remainders[pos+1] = qf.Remainders[pos+1]&^(mask>>(64-shift)) | r>>(64-shift)
+	}
+}
+
+func getBit(words []uint64, i uint64) bool {
+	return (words[i>>6]>>(i&63))&1 == 1
+}
+
+func setBit(words []uint64, i uint64) {
+	words[i>>6] |= uint64(1) << (i & 63)
+}
+
+func clearBit(words []uint64, i uint64) {
+	words[i>>6] &^= uint64(1) << (i & 63)
+}
+
+// Serialize the filter to a byte slice in the following format:
+// header|occupied|continuation|shifted|remainders
+// header format: uint32(Q)|uint32(R)|uint64(Seed)|uint64(Entries) => 4 + 4 + 8 + 8 = 24 bytes
+func (qf *QuotientFilter) Serialize() []byte {
+	words := len(qf.Occupied) + len(qf.Continuation) + len(qf.Shifted) + len(qf.Remainders)
+	buf := bytes.NewBuffer(make([]byte, 0, 24+words*8))
+	filter.SerializeUint(buf, uint64(qf.Q), 4)
+	filter.SerializeUint(buf, uint64(qf.R), 4)
+	filter.SerializeUint(buf, qf.Seed, 8)
+	filter.SerializeUint(buf, qf.Entries, 8)
+	for _, array := range [][]uint64{qf.Occupied, qf.Continuation, qf.Shifted, qf.Remainders} {
+		for _, word := range array {
+			filter.SerializeUint(buf, word, 8)
+		}
+	}
+	return buf.Bytes()
+}
+
+func Deserialize(data []byte) *QuotientFilter {
+	buf := bytes.NewBuffer(data)
+	q := filter.DeserializeUint[uint32](buf, 4)
+	r := filter.DeserializeUint[uint32](buf, 4)
+	seed := filter.DeserializeUint[uint64](buf, 8)
+	qf := newQuotientFilter(q, r, seed)
+	qf.Entries = filter.DeserializeUint[uint64](buf, 8)
+	for _, array := range [][]uint64{qf.Occupied, qf.Continuation, qf.Shifted, qf.Remainders} {
+		for i := range array {
+			array[i] = filter.DeserializeUint[uint64](buf, 8)
+		}
+	}
+	return qf
+}
@@ -0,0 +1,273 @@
+package quotient_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter/quotient"
+)
+
+func TestNewQuotientFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		n      uint64
+		fpRate float64
+		q      uint32
+		r      uint32
+	}{
+		{"small", 100, 0.01, 7, 7},
+		{"medium", 10000, 0.001, 14, 10},
+		{"large", 1000000, 0.0001, 21, 14},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qf := quotient.NewQuotientFilter(tt.n, tt.fpRate)
+			if qf.Q != tt.q || qf.R != tt.r {
+				t.Errorf("expected Q=%d R=%d, got Q=%d R=%d", tt.q, tt.r, qf.Q, qf.R)
+			}
+			if qf.Capacity() < tt.n {
+				t.Errorf("expected capacity >= %d, got %d", tt.n, qf.Capacity())
+			}
+		})
+	}
+}
+
+func TestInsertAndExist(t *testing.T) {
+	n := 50000
+	qf := quotient.NewQuotientFilter(uint64(n), 0.01)
+
+	for i := 0; i < n; i++ {
+		if !qf.Insert([]byte(fmt.Sprintf("item_%d", i))) {
+			t.Fatalf("failed to insert item_%d", i)
+		}
+	}
+	if qf.Entries != uint64(n) {
+		t.Errorf("expected %d entries, got %d", n, qf.Entries)
+	}
+
+	for i := 0; i < n; i++ {
+		if !qf.Exist([]byte(fmt.Sprintf("item_%d", i))) {
+			t.Errorf("false negative for item_%d", i)
+		}
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	n := 50000
+	fpRate := 0.01
+	qf := quotient.NewQuotientFilter(uint64(n), fpRate)
+	for i := 0; i < n; i++ {
+		qf.Insert([]byte(fmt.Sprintf("inserted_%d", i)))
+	}
+
+	falsePositives := 0
+	testCount := 100000
+	for i := 0; i < testCount; i++ {
+		if qf.Exist([]byte(fmt.Sprintf("not_inserted_%d", i))) {
+			falsePositives++
+		}
+	}
+
+	rate := float64(falsePositives) / float64(testCount)
+	if rate > fpRate {
+		t.Errorf("false positive rate too high: %f (expected <= %f)", rate, fpRate)
+	}
+	t.Logf("False positive rate: %f", rate)
+}
+
+func TestDeleteAndCount(t *testing.T) {
+	qf := quotient.NewQuotientFilter(1000, 0.001)
+	data := []byte("duplicate")
+
+	for i := 0; i < 3; i++ {
+		qf.Insert(data)
+	}
+	if c := qf.Count(data); c != 3 {
+		t.Errorf("expected count 3, got %d", c)
+	}
+
+	for i := 2; i >= 0; i-- {
+		if !qf.Delete(data) {
+			t.Fatalf("failed to delete copy %d", i)
+		}
+		if c := qf.Count(data); c != uint64(i) {
+			t.Errorf("expected count %d, got %d", i, c)
+		}
+	}
+	if qf.Exist(data) {
+		t.Error("item should not exist after deleting every copy")
+	}
+	if qf.Delete(data) {
+		t.Error("should not be able to delete a missing item")
+	}
+	if qf.Entries != 0 {
+		t.Errorf("expected 0 entries, got %d", qf.Entries)
+	}
+}
+
+// TestRandomOperations compares the filter against a multiset of fingerprints
+// on a small table so clusters grow long and wrap around the end of the table.
+func TestRandomOperations(t *testing.T) {
+	qf := quotient.NewQuotientFilter(200, 0.01)
+	rng := rand.New(rand.NewSource(1))
+	expected := make(map[[2]uint64]uint64)
+	keys := make([][]byte, 400)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key_%d", i))
+	}
+
+	for op := 0; op < 20000; op++ {
+		key := keys[rng.Intn(len(keys))]
+		fq, fr := qf.Hash(key)
+		fp := [2]uint64{fq, fr}
+		if rng.Intn(2) == 0 {
+			if qf.Insert(key) {
+				expected[fp]++
+			} else if qf.Entries < qf.Capacity() {
+				t.Fatalf("insert failed with %d/%d entries", qf.Entries, qf.Capacity())
+			}
+		} else {
+			deleted := qf.Delete(key)
+			if deleted != (expected[fp] > 0) {
+				t.Fatalf("delete returned %v with %d stored copies", deleted, expected[fp])
+			}
+			if deleted {
+				expected[fp]--
+			}
+		}
+
+		if c := qf.Count(key); c != expected[fp] {
+			t.Fatalf("op %d: expected count %d, got %d", op, expected[fp], c)
+		}
+	}
+
+	for _, key := range keys {
+		fq, fr := qf.Hash(key)
+		if c := qf.Count(key); c != expected[[2]uint64{fq, fr}] {
+			t.Errorf("expected count %d for %s, got %d", expected[[2]uint64{fq, fr}], key, c)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	n := 5000
+	qf := quotient.NewQuotientFilter(uint64(n), 0.001)
+	q, r := qf.Q, qf.R
+	for i := 0; i < n; i++ {
+		qf.Insert([]byte(fmt.Sprintf("item_%d", i)))
+	}
+	qf.Insert([]byte("item_0"))
+
+	if err := qf.Resize(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if qf.Q != q+1 || qf.R != r-1 {
+		t.Errorf("expected Q=%d R=%d, got Q=%d R=%d", q+1, r-1, qf.Q, qf.R)
+	}
+	if qf.Entries != uint64(n+1) {
+		t.Errorf("expected %d entries, got %d", n+1, qf.Entries)
+	}
+	for i := 0; i < n; i++ {
+		if !qf.Exist([]byte(fmt.Sprintf("item_%d", i))) {
+			t.Errorf("false negative for item_%d after resize", i)
+		}
+	}
+	if c := qf.Count([]byte("item_0")); c < 2 {
+		t.Errorf("expected count >= 2 after resize, got %d", c)
+	}
+
+	// the resized filter keeps accepting keys
+	for i := n; i < 2*n; i++ {
+		if !qf.Insert([]byte(fmt.Sprintf("item_%d", i))) {
+			t.Fatalf("failed to insert item_%d after resize", i)
+		}
+	}
+
+	small := quotient.NewQuotientFilter(10, 0.5)
+	for small.R > 1 {
+		small.Resize()
+	}
+	if err := small.Resize(); err != quotient.ErrCannotResize {
+		t.Errorf("expected ErrCannotResize, got %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	n := 3000
+	a := quotient.NewQuotientFilter(uint64(n), 0.001)
+	b := quotient.NewQuotientFilter(uint64(n), 0.001)
+	b.Seed = a.Seed
+
+	for i := 0; i < n; i++ {
+		a.Insert([]byte(fmt.Sprintf("a_%d", i)))
+		b.Insert([]byte(fmt.Sprintf("b_%d", i)))
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.Entries != uint64(2*n) {
+		t.Errorf("expected %d entries, got %d", 2*n, a.Entries)
+	}
+	for i := 0; i < n; i++ {
+		if !a.Exist([]byte(fmt.Sprintf("a_%d", i))) || !a.Exist([]byte(fmt.Sprintf("b_%d", i))) {
+			t.Errorf("false negative for item %d after merge", i)
+		}
+	}
+
+	c := quotient.NewQuotientFilter(uint64(n), 0.001)
+	if err := a.Merge(c); err != quotient.ErrIncompatible {
+		t.Errorf("expected ErrIncompatible, got %v", err)
+	}
+
+	// merging a filter with itself doubles its fingerprints
+	if err := b.Merge(b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Entries != uint64(2*n) {
+		t.Errorf("expected %d entries, got %d", 2*n, b.Entries)
+	}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("b_%d", i))
+		if !b.Exist(key) || !b.Delete(key) || !b.Delete(key) {
+			t.Fatalf("expected b_%d twice after merging the filter with itself", i)
+		}
+	}
+}
+
+func TestEmptyFilter(t *testing.T) {
+	// 0 items are sized as 1, log2(0) would convert -Inf to uint32
+	qf := quotient.NewQuotientFilter(0, 0.01)
+	if one := quotient.NewQuotientFilter(1, 0.01); qf.Q != one.Q || qf.R != one.R {
+		t.Fatalf("expected Q=%d R=%d for 0 items, got Q=%d R=%d", one.Q, one.R, qf.Q, qf.R)
+	}
+	if !qf.Insert([]byte("key")) || !qf.Exist([]byte("key")) {
+		t.Fatal("failed to insert in a filter sized for 0 items")
+	}
+}
+
+func TestSerializeDeserialize(t *testing.T) {
+	n := 10000
+	qf := quotient.NewQuotientFilter(uint64(n), 0.01)
+	for i := 0; i < n; i++ {
+		qf.Insert([]byte(fmt.Sprintf("item_%d", i)))
+	}
+
+	deserialized := quotient.Deserialize(qf.Serialize())
+	if qf.Q != deserialized.Q || qf.R != deserialized.R {
+		t.Errorf("expected Q=%d R=%d, got Q=%d R=%d", qf.Q, qf.R, deserialized.Q, deserialized.R)
+	}
+	if qf.Seed != deserialized.Seed {
+		t.Errorf("expected seed %d, got %d", qf.Seed, deserialized.Seed)
+	}
+	if qf.Entries != deserialized.Entries {
+		t.Errorf("expected %d entries, got %d", qf.Entries, deserialized.Entries)
+	}
+	for i := 0; i < n; i++ {
+		if !deserialized.Exist([]byte(fmt.Sprintf("item_%d", i))) {
+			t.Errorf("false negative for item_%d after deserialization", i)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package ribbon
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/bits"
+	"math/rand"
+
+	"github.com/rag-nar1/Filters/filter"
+	"github.com/zeebo/xxh3"
+)
+
+// every key is an equation over Width consecutive slots of the solution,
+// the banding matrix needs some slots more than keys to stay solvable
+const (
+	Width          = 64
+	MaxResultBits  = 32
+	MaxIterations  = 20
+	GrowthPerRetry = 0.02 // extra slots added every time construction fails twice
+)
+
+var ErrConstructionFailed = errors.New("ribbon: construction failed, too many attempts")
+
+// RibbonFilter is a static filter storing the solution of the linear system
+// coefficients(key) * Z = fingerprint(key) over GF(2), built with on-the-fly
+// gaussian elimination of a banded matrix ("Ribbon filter", Dillinger & Walzer).
+//
+// The solution is interleaved by blocks of Width slots: blocks before UpperStart
+// store ResultBits columns and the following ones ResultBits+1 columns, so the
+// average number of bits per key, and the false positive rate, need not be an integer power of two.
+type RibbonFilter struct {
+	Seed       uint64
+	Slots      uint32 // number of rows of the solution, multiple of Width
+	ResultBits uint32 // columns of the lower blocks
+	UpperStart uint32 // first block with ResultBits+1 columns
+
+	Solution []uint64
+
+	upperFraction float64 // fraction of blocks with ResultBits+1 columns
+}
+
+// NewRibbonFilter returns a filter whose result bits match fpRate.
+func NewRibbonFilter(fpRate float64) *RibbonFilter {
+	return NewRibbonFilterWithBits(-math.Log2(fpRate))
+}
+
+// NewRibbonFilterWithBits returns a filter using resultBits bits per slot, a
+// fractional value mixes blocks of floor(resultBits) and floor(resultBits)+1 columns.
+func NewRibbonFilterWithBits(resultBits float64) *RibbonFilter {
+	resultBits = min(max(resultBits, 1), MaxResultBits)
+	lower := math.Floor(resultBits)
+	// fpr = (1 - x) * 2^-lower + x * 2^-(lower+1)
+	fpRate := math.Pow(2, -resultBits)
+	upperFraction := 2 * (1 - fpRate*math.Pow(2, lower))
+	if lower == MaxResultBits {
+		upperFraction = 0
+	}
+	return &RibbonFilter{
+		ResultBits:    uint32(lower),
+		upperFraction: upperFraction,
+	}
+}
+
+// Populate builds the filter from keys, duplicated keys are allowed.
+func (rf *RibbonFilter) Populate(keys [][]byte) error {
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = xxh3.Hash(key)
+	}
+	return rf.PopulateUint64(hashes)
+}
+
+// PopulateUint64 builds the filter from already hashed keys.
+func (rf *RibbonFilter) PopulateUint64(keys []uint64) error {
+	overhead := Overhead(len(keys))
+	for attempt := range MaxIterations {
+		if attempt > 0 && attempt%2 == 0 {
+			overhead += GrowthPerRetry
+		}
+		rf.initialize(len(keys), overhead)
+		rf.Seed = rand.Uint64()
+
+		b := newBanding(rf)
+		ok := true
+		for _, key := range keys {
+			if !b.add(rf.hash(key)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			b.backSubstitute()
+			return nil
+		}
+	}
+	return ErrConstructionFailed
+}
+
+// Overhead returns the fraction of extra slots needed to build a filter of n keys,
+// with a Width of 64 it grows with log(n) to keep the construction failure rate low
+func Overhead(n int) float64 {
+	if n < 2 {
+		return 0
+	}
+	return max(0.02, 0.01*math.Log2(float64(n))-0.1)
+}
+
+func (rf *RibbonFilter) initialize(n int, overhead float64) {
+	slots := uint32(math.Ceil(float64(n)*(1+overhead))) + Width
+	blocks := (slots + Width - 1) / Width
+	rf.Slots = blocks * Width
+	rf.UpperStart = blocks - uint32(math.Round(float64(blocks)*rf.upperFraction))
+	rf.Solution = make([]uint64, rf.offset(blocks))
+}
+
+func (rf *RibbonFilter) Contains(data []byte) bool {
+	return rf.ContainsUint64(xxh3.Hash(data))
+}
+
+func (rf *RibbonFilter) ContainsUint64(key uint64) bool {
+	start, coefficients, fingerprint := rf.hash(key)
+	block, shift := start/Width, start%Width
+	columns := rf.columns(block)
+	lower := rf.Solution[rf.offset(block):]
+	var upper []uint64
+	if shift > 0 {
+		upper = rf.Solution[rf.offset(block+1):]
+	}
+
+	result := uint32(0)
+	for j := uint32(0); j < columns; j++ {
+		window := lower[j] >> shift
+		if shift > 0 {
+			window |= upper[j] << (Width - shift)
+		}
+		result |= uint32(bits.OnesCount64(coefficients&window)&1) << j
+	}
+	return result == fingerprint&columnMask(columns)
+}
+
+// AppendHash appends the slots of the solution combined for data to dst, the slots
+// of the set coefficients of its equation, reusing dst avoids any allocation
+func (rf *RibbonFilter) AppendHash(dst []int, data []byte) []int {
+	start, coefficients, _ := rf.hash(xxh3.Hash(data))
+	for ; coefficients != 0; coefficients &= coefficients - 1 {
+		dst = append(dst, int(start)+bits.TrailingZeros64(coefficients))
+	}
+	return dst
+}
+
+// hash returns the first slot, the Width coefficients starting at it and the fingerprint of key
+func (rf *RibbonFilter) hash(key uint64) (uint32, uint64, uint32) {
+	h := filter.Mix64(key + rf.Seed)
+	start, _ := bits.Mul64(h, uint64(rf.Slots-Width+1))
+	// the first coefficient is always set so the equation starts at its slot
+	coefficients := filter.Mix64(h) | 1
+	return uint32(start), coefficients, uint32(h)
+}
+
+func (rf *RibbonFilter) columns(block uint32) uint32 {
+	if block >= rf.UpperStart {
+		return rf.ResultBits + 1
+	}
+	return rf.ResultBits
+}
+
+// offset returns the index of the first column word of block
+func (rf *RibbonFilter) offset(block uint32) uint32 {
+	if block > rf.UpperStart {
+		return block*rf.ResultBits + block - rf.UpperStart
+	}
+	return block * rf.ResultBits
+}
+
+func columnMask(columns uint32) uint32 {
+	if columns >= 32 {
+		return ^uint32(0)
+	}
+	return uint32(1)<<columns - 1
+}
+
+// banding keeps one equation per slot, in row echelon form: the equation stored
+// at slot i has its first coefficient at i
+type banding struct {
+	rf           *RibbonFilter
+	coefficients []uint64
+	results      []uint32
+}
+
+func newBanding(rf *RibbonFilter) *banding {
+	return &banding{
+		rf:           rf,
+		coefficients: make([]uint64, rf.Slots),
+		results:      make([]uint32, rf.Slots),
+	}
+}
+
+// add eliminates the equation against the stored ones until it finds a free slot,
+// it fails when the equation reduces to 0 = 1, i.e. it contradicts the previous ones
+func (b *banding) add(start uint32, coefficients uint64, fingerprint uint32) bool {
+	mask := columnMask(b.rf.columns(start / Width))
+	result := fingerprint & mask
+	for {
+		if b.coefficients[start] == 0 {
+			b.coefficients[start] = coefficients
+			b.results[start] = result
+			return true
+		}
+		coefficients ^= b.coefficients[start]
+		result ^= b.results[start]
+		if coefficients == 0 {
+			// duplicated keys reduce to 0 = 0
+			return result&mask == 0
+		}
+		shift := uint32(bits.TrailingZeros64(coefficients))
+		start += shift
+		coefficients >>= shift
+	}
+}
+
+// backSubstitute solves the equations from the last slot to the first one,
+// slots without an equation are free and left to 0
+func (b *banding) backSubstitute() {
+	rf := b.rf
+	blocks := rf.Slots / Width
+	for block := int(blocks) - 1; block >= 0; block-- {
+		columns := rf.columns(uint32(block))
+		lower := rf.Solution[rf.offset(uint32(block)):]
+		var upper []uint64
+		if uint32(block)+1 < blocks {
+			upper = rf.Solution[rf.offset(uint32(block)+1):]
+		}
+
+		for shift := Width - 1; shift >= 0; shift-- {
+			slot := uint32(block)*Width + uint32(shift)
+			coefficients := b.coefficients[slot]
+			if coefficients == 0 {
+				continue
+			}
+			for j := uint32(0); j < columns; j++ {
+				window := lower[j] >> shift
+				if shift > 0 && upper != nil {
+					window |= upper[j] << (Width - shift)
+				}
+				bit := uint64(b.results[slot]>>j&1) ^ uint64(bits.OnesCount64(coefficients&window)&1)
+				lower[j] |= bit << shift
+			}
+		}
+	}
+}
+
+// Serialize the filter to a byte slice in the following format:
+// header|solution
+// header format: uint64(Seed)|uint32(Slots)|uint32(ResultBits)|uint32(UpperStart) => 8 + 4 + 4 + 4 = 20 bytes
+func (rf *RibbonFilter) Serialize() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 20+len(rf.Solution)*8))
+	filter.SerializeUint(buf, rf.Seed, 8)
+	filter.SerializeUint(buf, uint64(rf.Slots), 4)
+	filter.SerializeUint(buf, uint64(rf.ResultBits), 4)
+	filter.SerializeUint(buf, uint64(rf.UpperStart), 4)
+	for _, word := range rf.Solution {
+		filter.SerializeUint(buf, word, 8)
+	}
+	return buf.Bytes()
+}
+
+func Deserialize(data []byte) *RibbonFilter {
+	buf := bytes.NewBuffer(data)
+	rf := &RibbonFilter{
+		Seed:       filter.DeserializeUint[uint64](buf, 8),
+		Slots:      filter.DeserializeUint[uint32](buf, 4),
+		ResultBits: filter.DeserializeUint[uint32](buf, 4),
+		UpperStart: filter.DeserializeUint[uint32](buf, 4),
+	}
+	rf.Solution = make([]uint64, rf.offset(rf.Slots/Width))
+	for i := range rf.Solution {
+		rf.Solution[i] = filter.DeserializeUint[uint64](buf, 8)
+	}
+	return rf
+}
@@ -0,0 +1,120 @@
+package ribbon_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter/ribbon"
+)
+
+func generateKeys(prefix string, n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%s_%d", prefix, i))
+	}
+	return keys
+}
+
+func TestPopulateAndContains(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 100000} {
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			keys := generateKeys("item", n)
+			rf := ribbon.NewRibbonFilter(0.01)
+			if err := rf.Populate(keys); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, key := range keys {
+				if !rf.Contains(key) {
+					t.Fatalf("false negative for %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	keys := generateKeys("item", 1000)
+	keys = append(keys, keys[:500]...)
+	rf := ribbon.NewRibbonFilter(0.01)
+	if err := rf.Populate(keys); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range keys {
+		if !rf.Contains(key) {
+			t.Fatalf("false negative for %s", key)
+		}
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	n := 100000
+	keys := generateKeys("inserted", n)
+	others := generateKeys("not_inserted", 4*n)
+
+	for _, fpRate := range []float64{0.1, 0.03, 0.01, 1.0 / 128, 0.003, 0.0005} {
+		t.Run(fmt.Sprintf("fpRate=%v", fpRate), func(t *testing.T) {
+			rf := ribbon.NewRibbonFilter(fpRate)
+			if err := rf.Populate(keys); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			falsePositives := 0
+			for _, key := range others {
+				if rf.Contains(key) {
+					falsePositives++
+				}
+			}
+			rate := float64(falsePositives) / float64(len(others))
+			if math.Abs(rate-fpRate) > fpRate*0.15+0.0001 {
+				t.Errorf("false positive rate %f too far from %f", rate, fpRate)
+			}
+			bitsPerKey := float64(len(rf.Solution)*64) / float64(n)
+			t.Logf("false positive rate: %f, bits per key: %.2f (bloom needs %.2f)",
+				rate, bitsPerKey, -math.Log2(fpRate)/math.Ln2)
+		})
+	}
+}
+
+func TestResultBits(t *testing.T) {
+	keys := generateKeys("item", 10000)
+	for _, resultBits := range []float64{1, 4, 7.5, 16, 32} {
+		rf := ribbon.NewRibbonFilterWithBits(resultBits)
+		if err := rf.Populate(keys); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rf.ResultBits != uint32(resultBits) {
+			t.Errorf("expected %d result bits, got %d", uint32(resultBits), rf.ResultBits)
+		}
+		for _, key := range keys {
+			if !rf.Contains(key) {
+				t.Fatalf("false negative for %s with %v result bits", key, resultBits)
+			}
+		}
+	}
+}
+
+func TestSerializeDeserialize(t *testing.T) {
+	keys := generateKeys("item", 10000)
+	others := generateKeys("other", 10000)
+	rf := ribbon.NewRibbonFilter(0.005)
+	if err := rf.Populate(keys); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deserialized := ribbon.Deserialize(rf.Serialize())
+	if rf.Seed != deserialized.Seed || rf.Slots != deserialized.Slots ||
+		rf.ResultBits != deserialized.ResultBits || rf.UpperStart != deserialized.UpperStart {
+		t.Fatalf("header mismatch: %+v != %+v", rf, deserialized)
+	}
+	for _, key := range keys {
+		if !deserialized.Contains(key) {
+			t.Fatalf("false negative for %s after deserialization", key)
+		}
+	}
+	for _, key := range others {
+		if rf.Contains(key) != deserialized.Contains(key) {
+			t.Fatalf("deserialized filter disagrees on %s", key)
+		}
+	}
+}
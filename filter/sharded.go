@@ -0,0 +1,242 @@
+package filter
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+// shardSeed derives the shard of a key from its digest, independently of the bits the
+// shards themselves take from it
+const shardSeed = 0x5bd1e9955bd1e995
+
+var ErrInvalidSharded = errors.New("filter: invalid serialized sharded filter")
+
+// ShardFilter is implemented by the filters a Sharded can hold, they are used through their
+// digest methods. Bloom filters have lock-free variants instead, see bloom.ConcurrentBloomFilter.
+type ShardFilter interface {
+	InsertDigestFilter
+	Serialize() []byte
+}
+
+// DeleteShardFilter is implemented by the filters a DeletableSharded can hold, as cuckoo filters
+type DeleteShardFilter interface {
+	ShardFilter
+	DeleteDigest(d Digest) bool
+}
+
+// ShardedStats are the counters of a Sharded, summed over its shards
+type ShardedStats struct {
+	Shards        int
+	Inserts       uint64   // successful insertions
+	FailedInserts uint64   // insertions rejected by a full shard
+	Deletes       uint64   // successful deletions, of a DeletableSharded
+	ShardInserts  []uint64 // successful insertions minus deletions of every shard, shows the balance
+}
+
+// Sharded routes every key by hash to one of its shards, each behind its own lock, so that
+// writers of different shards never wait for each other. It wraps filters without a
+// lock-free variant, DeletableSharded adds deletions for shards supporting them. Every key goes through a Digest, so cuckoo filters deserialized from the
+// metro hashing format hold keys only found through digests, see cuckoo.InsertDigest.
+type Sharded[F ShardFilter] struct {
+	shards []shard[F]
+}
+
+type shard[F ShardFilter] struct {
+	mu      sync.RWMutex
+	f       F
+	inserts uint64
+	failed  uint64
+	deletes uint64
+	_       [CacheLineSize]byte // keeps the locks of neighbour shards on different cache lines
+}
+
+// NewSharded returns a filter of n shards built by newShard, each should be sized for its
+// share of the keys, e.g. n / shards items.
+func NewSharded[F ShardFilter](n int, newShard func(i int) F) *Sharded[F] {
+	shards := make([]F, max(n, 1))
+	for i := range shards {
+		shards[i] = newShard(i)
+	}
+	return newSharded(shards)
+}
+
+func newSharded[F ShardFilter](filters []F) *Sharded[F] {
+	s := &Sharded[F]{shards: make([]shard[F], len(filters))}
+	for i, f := range filters {
+		s.shards[i].f = f
+	}
+	return s
+}
+
+// Shard returns the shard of d and its index
+func (s *Sharded[F]) Shard(d Digest) (F, int) {
+	i := s.shardIndex(d)
+	return s.shards[i].f, i
+}
+
+func (s *Sharded[F]) shardIndex(d Digest) int {
+	return int(Reduce(uint32(d.Seeded(shardSeed)>>32), uint32(len(s.shards))))
+}
+
+func (s *Sharded[F]) Insert(data []byte) bool {
+	return s.InsertDigest(NewDigest(data))
+}
+
+func (s *Sharded[F]) Exist(data []byte) bool {
+	return s.ExistDigest(NewDigest(data))
+}
+
+func (s *Sharded[F]) InsertString(str string) bool {
+	return s.InsertDigest(NewDigestString(str))
+}
+
+func (s *Sharded[F]) ExistString(str string) bool {
+	return s.ExistDigest(NewDigestString(str))
+}
+
+func (s *Sharded[F]) InsertUint64(x uint64) bool {
+	return s.InsertDigest(NewDigestUint64(x))
+}
+
+func (s *Sharded[F]) ExistUint64(x uint64) bool {
+	return s.ExistDigest(NewDigestUint64(x))
+}
+
+// InsertDigest inserts the key of d in its shard and reports whether it was inserted
+func (s *Sharded[F]) InsertDigest(d Digest) bool {
+	sh := &s.shards[s.shardIndex(d)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if !sh.f.InsertDigest(d) {
+		sh.failed++
+		return false
+	}
+	sh.inserts++
+	return true
+}
+
+func (s *Sharded[F]) ExistDigest(d Digest) bool {
+	sh := &s.shards[s.shardIndex(d)]
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return sh.f.ExistDigest(d)
+}
+
+// Stats returns the counters of every shard, each shard is read under its lock
+func (s *Sharded[F]) Stats() ShardedStats {
+	stats := ShardedStats{Shards: len(s.shards), ShardInserts: make([]uint64, len(s.shards))}
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		stats.Inserts += sh.inserts
+		stats.FailedInserts += sh.failed
+		stats.Deletes += sh.deletes
+		stats.ShardInserts[i] = sh.inserts - sh.deletes
+		sh.mu.RUnlock()
+	}
+	return stats
+}
+
+// Serialize the shards to a byte slice in the following format:
+// uint32(number of shards)|shard 0|shard 1|...
+// shard format: uint64(length)|uint64(inserts)|uint64(failed)|uint64(deletes)|serialized filter
+// every shard is serialized under its lock, writers of the other shards can go on meanwhile
+func (s *Sharded[F]) Serialize() []byte {
+	buf := bytes.NewBuffer(nil)
+	SerializeUint(buf, uint64(len(s.shards)), 4)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		data := sh.f.Serialize()
+		SerializeUint(buf, uint64(len(data)), 8)
+		SerializeUint(buf, sh.inserts, 8)
+		SerializeUint(buf, sh.failed, 8)
+		SerializeUint(buf, sh.deletes, 8)
+		sh.mu.RUnlock()
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+// DeserializeSharded restores a Sharded serialized by Serialize, every shard is restored by
+// deserialize, e.g. bloom.Deserialize
+func DeserializeSharded[F ShardFilter](data []byte, deserialize func([]byte) F) (*Sharded[F], error) {
+	buf := bytes.NewBuffer(data)
+	if buf.Len() < 4 {
+		return nil, ErrInvalidSharded
+	}
+	n := DeserializeUint[uint32](buf, 4)
+	if n == 0 {
+		return nil, ErrInvalidSharded
+	}
+	filters := make([]F, 0, min(n, 1<<16))
+	counters := make([][3]uint64, 0, cap(filters))
+	for i := uint32(0); i < n; i++ {
+		if buf.Len() < 32 {
+			return nil, ErrInvalidSharded
+		}
+		length := DeserializeUint[uint64](buf, 8)
+		var c [3]uint64
+		for j := range c {
+			c[j] = DeserializeUint[uint64](buf, 8)
+		}
+		if uint64(buf.Len()) < length {
+			return nil, ErrInvalidSharded
+		}
+		filters = append(filters, deserialize(buf.Next(int(length))))
+		counters = append(counters, c)
+	}
+	if buf.Len() != 0 {
+		return nil, ErrInvalidSharded
+	}
+
+	s := newSharded(filters)
+	for i, c := range counters {
+		s.shards[i].inserts, s.shards[i].failed, s.shards[i].deletes = c[0], c[1], c[2]
+	}
+	return s, nil
+}
+
+// DeletableSharded is a Sharded whose shards support deletions
+type DeletableSharded[F DeleteShardFilter] struct {
+	*Sharded[F]
+}
+
+// NewDeletableSharded returns a filter of n shards built by newShard, as NewSharded
+func NewDeletableSharded[F DeleteShardFilter](n int, newShard func(i int) F) *DeletableSharded[F] {
+	return &DeletableSharded[F]{NewSharded(n, newShard)}
+}
+
+// DeserializeDeletableSharded restores a DeletableSharded serialized by Serialize, as DeserializeSharded
+func DeserializeDeletableSharded[F DeleteShardFilter](data []byte, deserialize func([]byte) F) (*DeletableSharded[F], error) {
+	s, err := DeserializeSharded(data, deserialize)
+	if err != nil {
+		return nil, err
+	}
+	return &DeletableSharded[F]{s}, nil
+}
+
+func (s *DeletableSharded[F]) Delete(data []byte) bool {
+	return s.DeleteDigest(NewDigest(data))
+}
+
+func (s *DeletableSharded[F]) DeleteString(str string) bool {
+	return s.DeleteDigest(NewDigestString(str))
+}
+
+func (s *DeletableSharded[F]) DeleteUint64(x uint64) bool {
+	return s.DeleteDigest(NewDigestUint64(x))
+}
+
+// DeleteDigest deletes the key of d from its shard and reports whether it was found
+func (s *DeletableSharded[F]) DeleteDigest(d Digest) bool {
+	sh := &s.shards[s.shardIndex(d)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if !sh.f.DeleteDigest(d) {
+		return false
+	}
+	sh.deletes++
+	return true
+}
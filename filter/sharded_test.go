@@ -0,0 +1,140 @@
+package filter_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter"
+	"github.com/rag-nar1/Filters/filter/bloom"
+	"github.com/rag-nar1/Filters/filter/cuckoo"
+)
+
+func TestSharded(t *testing.T) {
+	const shards, goroutines, perGoroutine = 8, 16, 2000
+	const n = goroutines * perGoroutine
+	s := filter.NewDeletableSharded(shards, func(int) *cuckoo.CuckooFilter {
+		return cuckoo.NewCuckooFilter(n/shards*5/4, 0.95)
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan string, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				key := fmt.Sprintf("key_%d_%d", g, i)
+				if !s.InsertString(key) || !s.ExistString(key) {
+					errs <- "failed to insert " + key
+					return
+				}
+				if i%4 == 0 && !s.DeleteString(key) {
+					errs <- "failed to delete " + key
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	stats := s.Stats()
+	if stats.Shards != shards || stats.Inserts != n || stats.Deletes != n/4 || stats.FailedInserts != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	total := uint64(0)
+	for i, inserts := range stats.ShardInserts {
+		// the keys are spread evenly over the shards
+		if inserts < n*3/4/shards*8/10 || inserts > n*3/4/shards*12/10 {
+			t.Errorf("shard %d holds %d keys out of %d", i, inserts, n*3/4)
+		}
+		total += inserts
+	}
+	if total != n*3/4 {
+		t.Errorf("shards hold %d keys, expected %d", total, n*3/4)
+	}
+
+	restored, err := filter.DeserializeDeletableSharded(s.Serialize(), cuckoo.Deserialize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restoredStats := restored.Stats(); restoredStats.Inserts != stats.Inserts || restoredStats.Deletes != stats.Deletes {
+		t.Fatalf("stats not restored: %+v", restoredStats)
+	}
+	for g := 0; g < goroutines; g++ {
+		for i := 1; i < perGoroutine; i++ {
+			key := []byte(fmt.Sprintf("key_%d_%d", g, i))
+			if i%4 != 0 && (!s.Exist(key) || !restored.Exist(key)) {
+				t.Fatalf("false negative for %s", key)
+			}
+		}
+	}
+	// the shard holding a key is the one found by Shard
+	d := filter.NewDigest([]byte("key_0_1"))
+	if shard, _ := restored.Shard(d); !shard.ExistDigest(d) {
+		t.Fatal("key_0_1 not found in its shard")
+	}
+
+	if _, err := filter.DeserializeSharded(s.Serialize()[:100], cuckoo.Deserialize); err == nil {
+		t.Fatal("expected an error for truncated data")
+	}
+}
+
+// bloomShard is a shard without deletions, whose insertions cannot fail
+type bloomShard struct{ *bloom.BloomFilter }
+
+func (s bloomShard) InsertDigest(d filter.Digest) bool {
+	s.BloomFilter.InsertDigest(d)
+	return true
+}
+
+func deserializeBloomShard(data []byte) bloomShard {
+	return bloomShard{bloom.Deserialize(data)}
+}
+
+func TestShardedWithoutDelete(t *testing.T) {
+	s := filter.NewSharded(3, func(int) bloomShard { return bloomShard{bloom.NewBloomFilter(1000, 0.01)} })
+	for i := uint64(0); i < 3000; i++ {
+		s.InsertUint64(i)
+	}
+	restored, err := filter.DeserializeSharded(s.Serialize(), deserializeBloomShard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < 3000; i++ {
+		if !restored.ExistUint64(i) {
+			t.Fatalf("false negative for %d", i)
+		}
+	}
+	if stats := restored.Stats(); stats.Inserts != 3000 || stats.Deletes != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// BenchmarkSharded measures insertions and deletions from many goroutines into a sharded
+// cuckoo filter, a single shard is a cuckoo filter behind a mutex
+func BenchmarkSharded(b *testing.B) {
+	const n = 1 << 22
+	for _, shards := range []int{1, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			s := filter.NewDeletableSharded(shards, func(int) *cuckoo.CuckooFilter {
+				return cuckoo.NewCuckooFilter(n/uint64(shards), 0.95)
+			})
+			b.RunParallel(func(pb *testing.PB) {
+				i := uint64(0)
+				for pb.Next() {
+					i++
+					if s.ExistUint64(i) {
+						s.DeleteUint64(i)
+					} else {
+						s.InsertUint64(i)
+					}
+				}
+			})
+		})
+	}
+}
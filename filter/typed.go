@@ -0,0 +1,71 @@
+package filter
+
+import "sync"
+
+// KeyEncoder appends the encoding of key to dst, keys are equal for a filter when their encodings are
+type KeyEncoder[K any] func(dst []byte, key K) []byte
+
+// DigestFilter is implemented by the filters probed from a Digest
+type DigestFilter interface {
+	ExistDigest(d Digest) bool
+}
+
+// InsertDigestFilter is a DigestFilter whose InsertDigest reports whether the key was inserted,
+// as cuckoo filters whose insertions fail once they are full
+type InsertDigestFilter interface {
+	DigestFilter
+	InsertDigest(d Digest) bool
+}
+
+// InfallibleFilter is a DigestFilter whose insertions cannot fail, as bloom filters
+type InfallibleFilter interface {
+	DigestFilter
+	InsertDigest(d Digest)
+}
+
+// Infallible adapts f to InsertDigestFilter, its insertions always report success
+func Infallible(f InfallibleFilter) InsertDigestFilter {
+	return infallible{f}
+}
+
+type infallible struct{ InfallibleFilter }
+
+func (f infallible) InsertDigest(d Digest) bool {
+	f.InfallibleFilter.InsertDigest(d)
+	return true
+}
+
+// Filter stores keys of type K in an underlying InsertDigestFilter, keys are turned into
+// digests by a pluggable function so callers never convert them to []byte themselves
+type Filter[K any] struct {
+	f      InsertDigestFilter
+	digest func(K) Digest
+}
+
+// NewFilterFunc wraps f, keys are hashed by digest, e.g. NewDigestString or NewDigestUint64.
+// Filters whose insertions cannot fail are wrapped by Infallible, e.g. Infallible(bf).
+func NewFilterFunc[K any](f InsertDigestFilter, digest func(K) Digest) *Filter[K] {
+	return &Filter[K]{f: f, digest: digest}
+}
+
+// NewFilter wraps f, keys are hashed from their encoding by encode, e.g. the fields of a struct.
+// Encodings are written to pooled buffers, so encode should not allocate either.
+func NewFilter[K any](f InsertDigestFilter, encode KeyEncoder[K]) *Filter[K] {
+	pool := sync.Pool{New: func() any { return new([]byte) }}
+	return NewFilterFunc(f, func(key K) Digest {
+		buf := pool.Get().(*[]byte)
+		*buf = encode((*buf)[:0], key)
+		d := NewDigest(*buf)
+		pool.Put(buf)
+		return d
+	})
+}
+
+// Insert adds key and reports whether it was inserted, only cuckoo like filters can fail
+func (f *Filter[K]) Insert(key K) bool {
+	return f.f.InsertDigest(f.digest(key))
+}
+
+func (f *Filter[K]) Exist(key K) bool {
+	return f.f.ExistDigest(f.digest(key))
+}
@@ -0,0 +1,113 @@
+package filter_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter"
+	blockedbloom "github.com/rag-nar1/Filters/filter/blocked-bloom"
+	"github.com/rag-nar1/Filters/filter/bloom"
+	"github.com/rag-nar1/Filters/filter/cuckoo"
+)
+
+// typedFilter is implemented by the filters with string and integer keys
+type typedFilter interface {
+	filter.DigestFilter
+	ExistString(s string) bool
+	ExistUint64(x uint64) bool
+}
+
+// typedCase is a filter with its InsertDigestFilter, bloom filters are adapted by filter.Infallible
+type typedCase struct {
+	typedFilter
+	digest filter.InsertDigestFilter
+}
+
+func typedFilters(n uint64) map[string]typedCase {
+	bf := bloom.NewBloomFilter(n, 0.01)
+	bbf := blockedbloom.NewBlockedBloomFilter(n, 0.01)
+	cf := cuckoo.NewCuckooFilter(n, 0.9)
+	return map[string]typedCase{
+		"Bloom":        {bf, filter.Infallible(bf)},
+		"BlockedBloom": {bbf, filter.Infallible(bbf)},
+		"Cuckoo":       {cf, cf},
+	}
+}
+
+type point struct {
+	X, Y int32
+	Tag  string
+}
+
+func encodePoint(dst []byte, p point) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(p.X))
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(p.Y))
+	return append(dst, p.Tag...)
+}
+
+func TestTypedKeys(t *testing.T) {
+	const n = 10000
+	for name, f := range typedFilters(n) {
+		strings := filter.NewFilterFunc(f.digest, filter.NewDigestString)
+		integers := filter.NewFilterFunc(f.digest, filter.NewDigestUint64)
+		points := filter.NewFilter(f.digest, encodePoint)
+		for i := 0; i < n/2; i++ {
+			if !strings.Insert(fmt.Sprintf("key_%d", i)) || !integers.Insert(uint64(i)) ||
+				!points.Insert(point{int32(i), -int32(i), "p"}) {
+				t.Fatalf("%s: failed to insert key %d", name, i)
+			}
+		}
+
+		for i := 0; i < n/2; i++ {
+			key := fmt.Sprintf("key_%d", i)
+			if !f.ExistString(key) || !strings.Exist(key) {
+				t.Fatalf("%s: false negative for %s", name, key)
+			}
+			if !f.ExistUint64(uint64(i)) || !integers.Exist(uint64(i)) {
+				t.Fatalf("%s: false negative for %d", name, i)
+			}
+			p := point{int32(i), -int32(i), "p"}
+			if !points.Exist(p) || !f.ExistDigest(filter.NewDigest(encodePoint(nil, p))) {
+				t.Fatalf("%s: false negative for %v", name, p)
+			}
+		}
+		// strings are hashed as their bytes
+		for i := 0; i < 2*n; i++ {
+			key := fmt.Sprintf("other_%d", i)
+			if f.ExistString(key) != f.ExistDigest(filter.NewDigest([]byte(key))) {
+				t.Fatalf("%s: ExistString and ExistDigest disagree on %s", name, key)
+			}
+		}
+	}
+}
+
+// BenchmarkTypedKeys asserts that lookups of string, integer and struct keys do not allocate
+func BenchmarkTypedKeys(b *testing.B) {
+	const n = 1 << 20
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key_%d", i)
+	}
+	for name, f := range typedFilters(n) {
+		points := filter.NewFilter(f.digest, encodePoint)
+		bench := map[string]func(i int) bool{
+			"Bytes":  func(i int) bool { return f.ExistDigest(filter.NewDigest([]byte(keys[i&1023]))) },
+			"String": func(i int) bool { return f.ExistString(keys[i&1023]) },
+			"Uint64": func(i int) bool { return f.ExistUint64(uint64(i)) },
+			"Struct": func(i int) bool { return points.Exist(point{int32(i), 1, keys[i&1023]}) },
+		}
+		for kind, exist := range bench {
+			b.Run(name+"/"+kind, func(b *testing.B) {
+				if allocs := testing.AllocsPerRun(100, func() { exist(7) }); allocs != 0 {
+					b.Fatalf("expected 0 allocs/op, got %v", allocs)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					exist(i)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package vacuum
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+
+	"github.com/dgryski/go-metro"
+	"github.com/rag-nar1/Filters/filter"
+)
+
+// fingerprint is considered as a single byte(8 bits)
+// number of entries per bucket is 4
+const (
+	FpSize     = 8
+	BucketSize = 4
+	MaxKicks   = 500
+	FPNULL     = 0
+	Ranges     = 4 // number of alternate ranges, a fingerprint uses AlternateRanges[fp%Ranges]
+)
+
+// VacuumFilter is a cuckoo filter whose alternate bucket is searched in an aligned
+// chunk of AlternateRanges[fp%Ranges] buckets around the first one ("Vacuum Filters", Wang et al.),
+// small ranges keep both buckets of most keys close in memory, the largest range
+// balances the load between chunks, and M only needs to be a multiple of the largest range.
+type VacuumFilter struct {
+	M               uint32 // number of buckets
+	Buckets         [][BucketSize]byte
+	Seed            uint64
+	FpSeed          uint64
+	AlternateRanges [Ranges]uint32 // powers of two, in decreasing order
+
+	fpHashes [256]uint32
+}
+
+func NewVacuumFilter(n uint64, loadFactor float64) *VacuumFilter {
+	m := uint32(math.Ceil(float64(n) / float64(BucketSize) / loadFactor))
+	m = max(m, 1)
+	ranges := selectRanges(m, loadFactor)
+	// round m up to a whole number of chunks of the largest range
+	m = (m + ranges[0] - 1) / ranges[0] * ranges[0]
+	return newVacuumFilter(m, ranges, rand.Uint64(), rand.Uint64())
+}
+
+func newVacuumFilter(m uint32, ranges [Ranges]uint32, seed, fpSeed uint64) *VacuumFilter {
+	vf := &VacuumFilter{
+		M:               m,
+		Buckets:         make([][BucketSize]byte, m),
+		Seed:            seed,
+		FpSeed:          fpSeed,
+		AlternateRanges: ranges,
+	}
+	for fp := range vf.fpHashes {
+		vf.fpHashes[fp] = uint32(metro.Hash64([]byte{byte(fp)}, fpSeed) >> 32)
+	}
+	return vf
+}
+
+// selectRanges picks the largest range as the smallest chunk whose number of keys stays
+// below its capacity, half of the fingerprints use it and the others a half and a quarter of it,
+// so many alternate buckets are close by without hurting the reachable load
+func selectRanges(m uint32, loadFactor float64) [Ranges]uint32 {
+	// the keys of a chunk of L buckets follow a Poisson law of mean BucketSize * L * loadFactor,
+	// the free slots of the chunk must cover a few standard deviations of it
+	largest := uint32(1)
+	for largest < m {
+		mean := float64(BucketSize*largest) * loadFactor
+		if float64(BucketSize*largest)-mean >= 6*math.Sqrt(mean) {
+			break
+		}
+		largest <<= 1
+	}
+	largest = min(largest, filter.NextPowerOfTwo(m))
+
+	return [Ranges]uint32{largest, largest, max(largest>>1, 1), max(largest>>2, 1)}
+}
+
+func (vf *VacuumFilter) Insert(data []byte) bool {
+	h1, fingerprint := vf.Hash(data)
+	if vf.BucketInsert(fingerprint, h1) {
+		return true
+	}
+	h2 := vf.AlternateIndex(h1, fingerprint)
+	if vf.BucketInsert(fingerprint, h2) {
+		return true
+	}
+	return vf.InsertFingerprint(fingerprint, RandomChoise(h1, h2), 1)
+}
+
+// InsertFingerprint stores fingerprint in bucket h or moves it along a kick path, it returns
+// false after MaxKicks kicks and then puts every kicked fingerprint back, so a failed
+// insertion loses none of the keys already in the filter.
+func (vf *VacuumFilter) InsertFingerprint(fingerprint byte, h uint32, kickingIdx uint32) bool {
+	var kicks [MaxKicks]kick
+	n := 0
+	for ; kickingIdx <= MaxKicks; kickingIdx++ {
+		if vf.BucketInsert(fingerprint, h) || vf.moveToAlternate(fingerprint, h) {
+			return true
+		}
+
+		// kick a random entry to avoid going through the same graph cycle
+		randomIndex := rand.Intn(BucketSize)
+		kicks[n] = kick{bucket: h, slot: randomIndex, fingerprint: vf.Buckets[h][randomIndex]}
+		n++
+		fingerprint, vf.Buckets[h][randomIndex] = vf.Buckets[h][randomIndex], fingerprint
+		h = vf.AlternateIndex(h, fingerprint)
+	}
+	// every kick only wrote its slot, restoring them backwards restores the filter
+	for i := n - 1; i >= 0; i-- {
+		vf.Buckets[kicks[i].bucket][kicks[i].slot] = kicks[i].fingerprint
+	}
+	return false
+}
+
+// moveToAlternate looks one kick ahead: it moves an entry of the full bucket h to a free slot
+// of its alternate bucket, if any has one, and stores fingerprint in its place
+func (vf *VacuumFilter) moveToAlternate(fingerprint byte, h uint32) bool {
+	for i, entry := range vf.Buckets[h] {
+		if vf.BucketInsert(entry, vf.AlternateIndex(h, entry)) {
+			vf.Buckets[h][i] = fingerprint
+			return true
+		}
+	}
+	return false
+}
+
+// kick is a slot overwritten by InsertFingerprint and the fingerprint it held
+type kick struct {
+	bucket      uint32
+	slot        int
+	fingerprint byte
+}
+
+func (vf *VacuumFilter) Lookup(data []byte) bool {
+	h1, fingerprint := vf.Hash(data)
+	if vf.bucketContains(h1, fingerprint) {
+		return true
+	}
+	return vf.bucketContains(vf.AlternateIndex(h1, fingerprint), fingerprint)
+}
+
+func (vf *VacuumFilter) Delete(data []byte) bool {
+	h1, fingerprint := vf.Hash(data)
+	if vf.bucketDelete(h1, fingerprint) {
+		return true
+	}
+	return vf.bucketDelete(vf.AlternateIndex(h1, fingerprint), fingerprint)
+}
+
+// returns the fingerprint and the index of the first bucket
+func (vf *VacuumFilter) Hash(data []byte) (uint32, byte) {
+	hash := metro.Hash64(data, vf.Seed)
+
+	// map the most significant 32 bits to [0, M) with a multiply and shift
+	h1 := uint32(((hash >> 32) * uint64(vf.M)) >> 32)
+	fingerprint := byte(hash) // least significant 8 bits
+	if fingerprint == FPNULL {
+		fingerprint = 1
+	}
+	return h1, fingerprint
+}
+
+// AppendHash appends the indexes of both candidate buckets of data to dst,
+// reusing dst avoids any allocation
+func (vf *VacuumFilter) AppendHash(dst []int, data []byte) []int {
+	h1, fingerprint := vf.Hash(data)
+	return append(dst, int(h1), int(vf.AlternateIndex(h1, fingerprint)))
+}
+
+// AlternateIndex flips the low bits of h, so both buckets lie in the same aligned chunk
+// of the fingerprint's range, and applying it twice gives h back.
+func (vf *VacuumFilter) AlternateIndex(h uint32, fingerprint byte) uint32 {
+	rangeMask := vf.AlternateRanges[fingerprint%Ranges] - 1
+	if rangeMask == 0 {
+		return h
+	}
+	// a non zero offset keeps the two buckets distinct
+	return h ^ (vf.fpHashes[fingerprint]&rangeMask | 1)
+}
+
+func (vf *VacuumFilter) BucketInsert(fingerprint byte, h uint32) bool {
+	for i := range vf.Buckets[h] {
+		if vf.Buckets[h][i] == FPNULL {
+			vf.Buckets[h][i] = fingerprint
+			return true
+		}
+	}
+	return false
+}
+
+func (vf *VacuumFilter) bucketContains(h uint32, fingerprint byte) bool {
+	for _, val := range vf.Buckets[h] {
+		if val == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+func (vf *VacuumFilter) bucketDelete(h uint32, fingerprint byte) bool {
+	for i, val := range vf.Buckets[h] {
+		if val == fingerprint {
+			vf.Buckets[h][i] = FPNULL
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFactor returns the fraction of occupied entries
+func (vf *VacuumFilter) LoadFactor() float64 {
+	used := 0
+	for _, bucket := range vf.Buckets {
+		for _, val := range bucket {
+			if val != FPNULL {
+				used++
+			}
+		}
+	}
+	return float64(used) / float64(len(vf.Buckets)*BucketSize)
+}
+
+func RandomChoise[T any](a T, b T) T {
+	if rand.Intn(2) == 0 {
+		return a
+	}
+	return b
+}
+
+// Serialize the filter to a byte slice in the following format:
+// header|buckets
+// header format: uint32(M)|uint64(FpSeed)|uint64(Seed)|4 x uint32(AlternateRanges) => 4 + 8 + 8 + 16 = 36 bytes
+func (vf *VacuumFilter) Serialize() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 36+vf.M*BucketSize))
+
+	filter.SerializeUint(buf, uint64(vf.M), 4)
+	filter.SerializeUint(buf, vf.FpSeed, 8)
+	filter.SerializeUint(buf, vf.Seed, 8)
+	for _, r := range vf.AlternateRanges {
+		filter.SerializeUint(buf, uint64(r), 4)
+	}
+
+	for _, bucket := range vf.Buckets {
+		buf.Write(bucket[:])
+	}
+
+	return buf.Bytes()
+}
+
+func Deserialize(data []byte) *VacuumFilter {
+	buf := bytes.NewBuffer(data)
+
+	m := filter.DeserializeUint[uint32](buf, 4)
+	fpSeed := filter.DeserializeUint[uint64](buf, 8)
+	seed := filter.DeserializeUint[uint64](buf, 8)
+	var ranges [Ranges]uint32
+	for i := range ranges {
+		ranges[i] = filter.DeserializeUint[uint32](buf, 4)
+	}
+
+	vf := newVacuumFilter(m, ranges, seed, fpSeed)
+	for i := range vf.Buckets {
+		buf.Read(vf.Buckets[i][:])
+	}
+
+	return vf
+}
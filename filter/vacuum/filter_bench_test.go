@@ -0,0 +1,124 @@
+package vacuum_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter/cuckoo"
+	"github.com/rag-nar1/Filters/filter/vacuum"
+)
+
+// the common operations of both filters, to run the same benchmarks on each of them
+type deletableFilter interface {
+	Insert(data []byte) bool
+	Lookup(data []byte) bool
+	Delete(data []byte) bool
+}
+
+type filterCase struct {
+	name string
+	new  func(n uint64, loadFactor float64) (deletableFilter, int) // filter and its size in bytes
+}
+
+var filterCases = []filterCase{
+	{"Vacuum", func(n uint64, loadFactor float64) (deletableFilter, int) {
+		vf := vacuum.NewVacuumFilter(n, loadFactor)
+		return vf, int(vf.M) * vacuum.BucketSize
+	}},
+	{"Cuckoo", func(n uint64, loadFactor float64) (deletableFilter, int) {
+		cf := cuckoo.NewCuckooFilter(n, loadFactor)
+		return cf, int(cf.M) * cuckoo.BucketSize
+	}},
+}
+
+// BenchmarkInsertHighLoad measures the insertion of the last 10% of the items of a
+// filter sized for n items at a 95% load, where kicks dominate the insertion time,
+// the cuckoo filter rounds its table up to a power of two so its real load may be lower
+func BenchmarkInsertHighLoad(b *testing.B) {
+	n := 1 << 20
+	items := generateItems("item", n)
+	for _, fc := range filterCases {
+		b.Run(fc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			failures := 0
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				f, _ := fc.new(uint64(n), 0.95)
+				for _, item := range items[:n*9/10] {
+					f.Insert(item)
+				}
+				b.StartTimer()
+				for _, item := range items[n*9/10:] {
+					if !f.Insert(item) {
+						failures++
+					}
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*(n-n*9/10)), "ns/insert")
+			b.ReportMetric(float64(failures)/float64(b.N), "failures/op")
+		})
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	n := 1 << 20
+	items := generateItems("item", n)
+	others := generateItems("other", n)
+	for _, fc := range filterCases {
+		f, _ := fc.new(uint64(n), 0.95)
+		for _, item := range items {
+			f.Insert(item)
+		}
+
+		b.Run(fc.name+"/Positive", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				f.Lookup(items[i%n])
+			}
+		})
+		b.Run(fc.name+"/Negative", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				f.Lookup(others[i%n])
+			}
+		})
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	n := 1 << 18
+	items := generateItems("item", n)
+	for _, fc := range filterCases {
+		b.Run(fc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i += n {
+				b.StopTimer()
+				f, _ := fc.new(uint64(n), 0.95)
+				for _, item := range items {
+					f.Insert(item)
+				}
+				b.StartTimer()
+				for j := 0; j < n && i+j < b.N; j++ {
+					f.Delete(items[j])
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkMemory reports the table size of both filters, the cuckoo filter rounds the
+// number of buckets up to a power of two while the vacuum filter only rounds it to its largest range
+func BenchmarkMemory(b *testing.B) {
+	for _, n := range []uint64{100000, 1000000, 3000000} {
+		for _, fc := range filterCases {
+			b.Run(fmt.Sprintf("%s/n=%d", fc.name, n), func(b *testing.B) {
+				var size int
+				for i := 0; i < b.N; i++ {
+					_, size = fc.new(n, 0.95)
+				}
+				b.ReportMetric(float64(size)*8/float64(n), "bits/key")
+				b.ReportMetric(float64(size)/1024, "KB")
+			})
+		}
+	}
+}
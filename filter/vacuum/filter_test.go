@@ -0,0 +1,171 @@
+package vacuum_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter/vacuum"
+)
+
+func generateItems(prefix string, n int) [][]byte {
+	items := make([][]byte, n)
+	for i := range items {
+		items[i] = []byte(fmt.Sprintf("%s_%d", prefix, i))
+	}
+	return items
+}
+
+func TestNewVacuumFilter(t *testing.T) {
+	for _, n := range []uint64{1, 100, 10000, 1000000} {
+		vf := vacuum.NewVacuumFilter(n, 0.95)
+		largest := vf.AlternateRanges[0]
+		if vf.M%largest != 0 {
+			t.Errorf("n=%d: M=%d is not a multiple of the largest range %d", n, vf.M, largest)
+		}
+		if uint64(vf.M)*vacuum.BucketSize < n {
+			t.Errorf("n=%d: M=%d buckets cannot hold the items", n, vf.M)
+		}
+		for i, r := range vf.AlternateRanges {
+			if r == 0 || r&(r-1) != 0 || r > largest {
+				t.Errorf("n=%d: invalid range %d at %d", n, r, i)
+			}
+		}
+	}
+
+	// unlike the cuckoo filter, M is not rounded up to a power of two
+	vf := vacuum.NewVacuumFilter(1000000, 0.95)
+	if vf.M&(vf.M-1) == 0 {
+		t.Errorf("expected a non power of two M, got %d", vf.M)
+	}
+}
+
+func TestAlternateIndex(t *testing.T) {
+	vf := vacuum.NewVacuumFilter(100000, 0.95)
+	for _, item := range generateItems("item", 10000) {
+		h1, fingerprint := vf.Hash(item)
+		if h1 >= vf.M {
+			t.Fatalf("index %d out of range [0, %d)", h1, vf.M)
+		}
+		h2 := vf.AlternateIndex(h1, fingerprint)
+		if h2 >= vf.M {
+			t.Fatalf("alternate index %d out of range [0, %d)", h2, vf.M)
+		}
+		if h1 == h2 {
+			t.Fatalf("alternate index of %d is the same bucket", h1)
+		}
+		if vf.AlternateIndex(h2, fingerprint) != h1 {
+			t.Fatalf("alternate index of %d is not an involution", h1)
+		}
+		// both buckets lie in the same chunk of the fingerprint's range
+		r := vf.AlternateRanges[fingerprint%vacuum.Ranges]
+		if h1/r != h2/r {
+			t.Fatalf("buckets %d and %d are not in the same chunk of %d", h1, h2, r)
+		}
+	}
+}
+
+func TestInsertLookup(t *testing.T) {
+	n := 200000
+	vf := vacuum.NewVacuumFilter(uint64(n), 0.95)
+	items := generateItems("item", n)
+	for _, item := range items {
+		if !vf.Insert(item) {
+			t.Fatalf("failed to insert %s at load factor %.4f", item, vf.LoadFactor())
+		}
+	}
+	for _, item := range items {
+		if !vf.Lookup(item) {
+			t.Fatalf("false negative for %s", item)
+		}
+	}
+
+	falsePositives := 0
+	others := generateItems("other", n)
+	for _, item := range others {
+		if vf.Lookup(item) {
+			falsePositives++
+		}
+	}
+	// two buckets of 4 entries with 8 bits fingerprints, 8/255
+	rate := float64(falsePositives) / float64(len(others))
+	if rate > 0.04 {
+		t.Errorf("false positive rate too high: %f", rate)
+	}
+	t.Logf("load factor: %.4f, false positive rate: %f", vf.LoadFactor(), rate)
+}
+
+func TestHighLoad(t *testing.T) {
+	n := 1000000
+	vf := vacuum.NewVacuumFilter(uint64(n), 0.95)
+	for i, item := range generateItems("item", n) {
+		if !vf.Insert(item) {
+			t.Fatalf("insertion failed after %d items, load factor %.4f", i, vf.LoadFactor())
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	vf := vacuum.NewVacuumFilter(10000, 0.95)
+	items := generateItems("item", 10000)
+	for _, item := range items {
+		vf.Insert(item)
+	}
+	for _, item := range items[:5000] {
+		if !vf.Delete(item) {
+			t.Fatalf("failed to delete %s", item)
+		}
+	}
+	for _, item := range items[5000:] {
+		if !vf.Lookup(item) {
+			t.Fatalf("false negative for %s after deleting other items", item)
+		}
+	}
+}
+
+func TestSerializeDeserialize(t *testing.T) {
+	vf := vacuum.NewVacuumFilter(10000, 0.9)
+	items := generateItems("item", 9000)
+	for _, item := range items {
+		vf.Insert(item)
+	}
+
+	deserialized := vacuum.Deserialize(vf.Serialize())
+	if vf.M != deserialized.M || vf.Seed != deserialized.Seed || vf.FpSeed != deserialized.FpSeed ||
+		vf.AlternateRanges != deserialized.AlternateRanges {
+		t.Fatal("header mismatch")
+	}
+	for i := range vf.Buckets {
+		if !bytes.Equal(vf.Buckets[i][:], deserialized.Buckets[i][:]) {
+			t.Fatalf("bucket %d mismatch", i)
+		}
+	}
+	for _, item := range items {
+		if !deserialized.Lookup(item) {
+			t.Fatalf("false negative for %s after deserialization", item)
+		}
+	}
+}
+
+func TestInsertUntilFull(t *testing.T) {
+	n := 10000
+	vf := vacuum.NewVacuumFilter(uint64(n), 0.95)
+	var accepted [][]byte
+	failures := 0
+	for _, item := range generateItems("item", 2*n) {
+		if vf.Insert(item) {
+			accepted = append(accepted, item)
+		} else {
+			failures++
+		}
+	}
+	if failures == 0 {
+		t.Fatal("expected the filter to fill up")
+	}
+	// a failed insertion must not evict the fingerprint of an accepted key
+	for _, item := range accepted {
+		if !vf.Lookup(item) {
+			t.Fatalf("%s lost after %d failed insertions", item, failures)
+		}
+	}
+}
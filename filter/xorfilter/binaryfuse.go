@@ -0,0 +1,137 @@
+package xorfilter
+
+import (
+	"bytes"
+	"math"
+	"math/bits"
+
+	"github.com/rag-nar1/Filters/filter"
+	"github.com/zeebo/xxh3"
+)
+
+// MaxSegmentLength bounds the segment length for very large key sets
+const MaxSegmentLength = 1 << 18
+
+// BinaryFuse is a static filter like Xor, but the three slots of a key are taken
+// from three consecutive segments, which brings the space overhead from 23% down to ~13%.
+type BinaryFuse[T Fingerprint] struct {
+	Seed               uint64
+	SegmentLength      uint32
+	SegmentLengthMask  uint32
+	SegmentCount       uint32
+	SegmentCountLength uint32
+	Fingerprints       []T // (SegmentCount + 2) * SegmentLength entries
+}
+
+type (
+	BinaryFuse8  = BinaryFuse[uint8]
+	BinaryFuse16 = BinaryFuse[uint16]
+)
+
+// Populate builds the filter from keys, duplicated keys are allowed.
+func (bf *BinaryFuse[T]) Populate(keys [][]byte) error {
+	return bf.PopulateUint64(hashKeys(keys))
+}
+
+// PopulateUint64 builds the filter from already hashed keys.
+func (bf *BinaryFuse[T]) PopulateUint64(keys []uint64) error {
+	bf.initialize(len(keys))
+
+	seed, order, err := populate(keys, len(bf.Fingerprints), bf.indexes)
+	if err != nil {
+		return err
+	}
+	bf.Seed = seed
+	assign(bf.Fingerprints, order, bf.indexes)
+	return nil
+}
+
+// initialize sets the segment layout for n keys, the constants come from
+// "Binary Fuse Filters: Fast and Smaller Than Xor Filters" (Graf & Lemire)
+func (bf *BinaryFuse[T]) initialize(n int) {
+	const arity = 3
+	segmentLength := uint32(4)
+	sizeFactor := 0.0
+	if n > 1 {
+		segmentLength = uint32(1) << int(math.Floor(math.Log(float64(n))/math.Log(3.33)+2.25))
+		sizeFactor = math.Max(1.125, 0.875+0.25*math.Log(1000000)/math.Log(float64(n)))
+	}
+	segmentLength = min(segmentLength, MaxSegmentLength)
+
+	capacity := uint32(math.Round(float64(n) * sizeFactor))
+	segmentCount := (capacity + segmentLength - 1) / segmentLength
+	if segmentCount <= arity-1 {
+		segmentCount = 1
+	} else {
+		segmentCount -= arity - 1
+	}
+
+	bf.SegmentLength = segmentLength
+	bf.SegmentLengthMask = segmentLength - 1
+	bf.SegmentCount = segmentCount
+	bf.SegmentCountLength = segmentCount * segmentLength
+	bf.Fingerprints = make([]T, (segmentCount+arity-1)*segmentLength)
+}
+
+func (bf *BinaryFuse[T]) Contains(data []byte) bool {
+	return bf.ContainsUint64(xxh3.Hash(data))
+}
+
+func (bf *BinaryFuse[T]) ContainsUint64(key uint64) bool {
+	hash := mixSeed(key, bf.Seed)
+	h := bf.indexes(hash)
+	return fingerprint[T](hash) == bf.Fingerprints[h[0]]^bf.Fingerprints[h[1]]^bf.Fingerprints[h[2]]
+}
+
+// AppendHash appends the indexes of the 3 fingerprints xored for data to dst,
+// reusing dst avoids any allocation
+func (bf *BinaryFuse[T]) AppendHash(dst []int, data []byte) []int {
+	h := bf.indexes(mixSeed(xxh3.Hash(data), bf.Seed))
+	return append(dst, int(h[0]), int(h[1]), int(h[2]))
+}
+
+func (bf *BinaryFuse[T]) indexes(hash uint64) [3]uint32 {
+	hi, _ := bits.Mul64(hash, uint64(bf.SegmentCountLength))
+	h0 := uint32(hi)
+	h1 := h0 + bf.SegmentLength
+	h2 := h1 + bf.SegmentLength
+	h1 ^= uint32(hash>>18) & bf.SegmentLengthMask
+	h2 ^= uint32(hash) & bf.SegmentLengthMask
+	return [3]uint32{h0, h1, h2}
+}
+
+// Serialize the filter to a byte slice in the following format:
+// header|fingerprints
+// header format: uint64(Seed)|uint32(SegmentLength)|uint32(SegmentCount) => 8 + 4 + 4 = 16 bytes
+func (bf *BinaryFuse[T]) Serialize() []byte {
+	size := fingerprintSize[T]()
+	buf := bytes.NewBuffer(make([]byte, 0, 16+len(bf.Fingerprints)*size))
+	filter.SerializeUint(buf, bf.Seed, 8)
+	filter.SerializeUint(buf, uint64(bf.SegmentLength), 4)
+	filter.SerializeUint(buf, uint64(bf.SegmentCount), 4)
+	serializeFingerprints(buf, bf.Fingerprints)
+	return buf.Bytes()
+}
+
+func DeserializeBinaryFuse8(data []byte) *BinaryFuse8 {
+	return deserializeBinaryFuse[uint8](data)
+}
+
+func DeserializeBinaryFuse16(data []byte) *BinaryFuse16 {
+	return deserializeBinaryFuse[uint16](data)
+}
+
+func deserializeBinaryFuse[T Fingerprint](data []byte) *BinaryFuse[T] {
+	buf := bytes.NewBuffer(data)
+	seed := filter.DeserializeUint[uint64](buf, 8)
+	segmentLength := filter.DeserializeUint[uint32](buf, 4)
+	segmentCount := filter.DeserializeUint[uint32](buf, 4)
+	return &BinaryFuse[T]{
+		Seed:               seed,
+		SegmentLength:      segmentLength,
+		SegmentLengthMask:  segmentLength - 1,
+		SegmentCount:       segmentCount,
+		SegmentCountLength: segmentCount * segmentLength,
+		Fingerprints:       deserializeFingerprints[T](buf, int((segmentCount+2)*segmentLength)),
+	}
+}
@@ -0,0 +1,231 @@
+package xorfilter
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"math/rand"
+	"slices"
+	"unsafe"
+
+	"github.com/rag-nar1/Filters/filter"
+	"github.com/zeebo/xxh3"
+)
+
+// MaxIterations is the number of seeds tried before construction gives up,
+// a single attempt fails with a small probability that shrinks with the number of keys
+const MaxIterations = 100
+
+var ErrConstructionFailed = errors.New("xorfilter: construction failed, too many attempts")
+
+// Fingerprint is the type of the values stored in the filter, it sets the
+// false positive rate to 1/256 for uint8 and 1/65536 for uint16
+type Fingerprint interface {
+	~uint8 | ~uint16
+}
+
+// Xor is a static filter built from a complete key set, each key is mapped to
+// three slots, one per block, whose fingerprints xor to the fingerprint of the key.
+type Xor[T Fingerprint] struct {
+	Seed         uint64
+	BlockLength  uint32
+	Fingerprints []T // 3 * BlockLength entries
+}
+
+type (
+	Xor8  = Xor[uint8]
+	Xor16 = Xor[uint16]
+)
+
+// Populate builds the filter from keys, duplicated keys are allowed.
+func (xf *Xor[T]) Populate(keys [][]byte) error {
+	return xf.PopulateUint64(hashKeys(keys))
+}
+
+// PopulateUint64 builds the filter from already hashed keys.
+func (xf *Xor[T]) PopulateUint64(keys []uint64) error {
+	size := 32 + (123*len(keys)+99)/100
+	xf.BlockLength = uint32(size / 3)
+	xf.Fingerprints = make([]T, 3*xf.BlockLength)
+
+	seed, order, err := populate(keys, len(xf.Fingerprints), xf.indexes)
+	if err != nil {
+		return err
+	}
+	xf.Seed = seed
+	assign(xf.Fingerprints, order, xf.indexes)
+	return nil
+}
+
+func (xf *Xor[T]) Contains(data []byte) bool {
+	return xf.ContainsUint64(xxh3.Hash(data))
+}
+
+func (xf *Xor[T]) ContainsUint64(key uint64) bool {
+	hash := mixSeed(key, xf.Seed)
+	h := xf.indexes(hash)
+	return fingerprint[T](hash) == xf.Fingerprints[h[0]]^xf.Fingerprints[h[1]]^xf.Fingerprints[h[2]]
+}
+
+// AppendHash appends the indexes of the 3 fingerprints xored for data to dst,
+// reusing dst avoids any allocation
+func (xf *Xor[T]) AppendHash(dst []int, data []byte) []int {
+	h := xf.indexes(mixSeed(xxh3.Hash(data), xf.Seed))
+	return append(dst, int(h[0]), int(h[1]), int(h[2]))
+}
+
+func (xf *Xor[T]) indexes(hash uint64) [3]uint32 {
+	return [3]uint32{
+		reduce(uint32(hash), xf.BlockLength),
+		reduce(uint32(bits.RotateLeft64(hash, 21)), xf.BlockLength) + xf.BlockLength,
+		reduce(uint32(bits.RotateLeft64(hash, 42)), xf.BlockLength) + 2*xf.BlockLength,
+	}
+}
+
+// Serialize the filter to a byte slice in the following format:
+// header|fingerprints
+// header format: uint64(Seed)|uint32(BlockLength) => 8 + 4 = 12 bytes
+func (xf *Xor[T]) Serialize() []byte {
+	size := fingerprintSize[T]()
+	buf := bytes.NewBuffer(make([]byte, 0, 12+len(xf.Fingerprints)*size))
+	filter.SerializeUint(buf, xf.Seed, 8)
+	filter.SerializeUint(buf, uint64(xf.BlockLength), 4)
+	serializeFingerprints(buf, xf.Fingerprints)
+	return buf.Bytes()
+}
+
+func DeserializeXor8(data []byte) *Xor8 {
+	return deserializeXor[uint8](data)
+}
+
+func DeserializeXor16(data []byte) *Xor16 {
+	return deserializeXor[uint16](data)
+}
+
+func deserializeXor[T Fingerprint](data []byte) *Xor[T] {
+	buf := bytes.NewBuffer(data)
+	xf := &Xor[T]{
+		Seed:        filter.DeserializeUint[uint64](buf, 8),
+		BlockLength: filter.DeserializeUint[uint32](buf, 4),
+	}
+	xf.Fingerprints = deserializeFingerprints[T](buf, int(3*xf.BlockLength))
+	return xf
+}
+
+// populate peels the keys with a fresh seed until every key owns a slot,
+// it returns the seed and the hashes of the keys in assignment order
+func populate(keys []uint64, size int, indexes func(uint64) [3]uint32) (uint64, []peeled, error) {
+	deduplicated := false
+	for range MaxIterations {
+		seed := rand.Uint64()
+		if order, ok := peel(keys, seed, size, indexes); ok {
+			return seed, order, nil
+		}
+		// duplicated keys can never be peeled, drop them once and retry
+		if !deduplicated {
+			keys = slices.Clone(keys)
+			slices.Sort(keys)
+			keys = slices.Compact(keys)
+			deduplicated = true
+		}
+	}
+	return 0, nil, ErrConstructionFailed
+}
+
+type peeled struct {
+	hash uint64
+	slot uint32
+}
+
+// peel repeatedly removes a key that is the only one mapped to one of its slots,
+// it fails when some keys are left that all share their slots with each other
+func peel(keys []uint64, seed uint64, size int, indexes func(uint64) [3]uint32) ([]peeled, bool) {
+	count := make([]uint32, size)
+	xorMask := make([]uint64, size)
+	for _, key := range keys {
+		hash := mixSeed(key, seed)
+		for _, h := range indexes(hash) {
+			count[h]++
+			xorMask[h] ^= hash
+		}
+	}
+
+	queue := make([]uint32, 0, size)
+	for i := range count {
+		if count[i] == 1 {
+			queue = append(queue, uint32(i))
+		}
+	}
+
+	order := make([]peeled, 0, len(keys))
+	for len(queue) > 0 {
+		slot := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if count[slot] != 1 {
+			continue
+		}
+		// the only key left in the slot is the xor of every key that was mapped to it
+		hash := xorMask[slot]
+		order = append(order, peeled{hash: hash, slot: slot})
+		for _, h := range indexes(hash) {
+			count[h]--
+			xorMask[h] ^= hash
+			if count[h] == 1 {
+				queue = append(queue, h)
+			}
+		}
+	}
+	return order, len(order) == len(keys)
+}
+
+// assign walks the peeling order backwards so the slot owned by a key is set
+// after every other slot of that key got its final value
+func assign[T Fingerprint](fingerprints []T, order []peeled, indexes func(uint64) [3]uint32) {
+	for i := len(order) - 1; i >= 0; i-- {
+		h := indexes(order[i].hash)
+		fingerprints[order[i].slot] = 0
+		fingerprints[order[i].slot] = fingerprint[T](order[i].hash) ^ fingerprints[h[0]] ^ fingerprints[h[1]] ^ fingerprints[h[2]]
+	}
+}
+
+func hashKeys(keys [][]byte) []uint64 {
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = xxh3.Hash(key)
+	}
+	return hashes
+}
+
+func mixSeed(key, seed uint64) uint64 {
+	return filter.Mix64(key + seed)
+}
+
+func fingerprint[T Fingerprint](hash uint64) T {
+	return T(hash ^ hash>>32)
+}
+
+// reduce maps x to [0, n) without a division
+func reduce(x, n uint32) uint32 {
+	return uint32((uint64(x) * uint64(n)) >> 32)
+}
+
+func fingerprintSize[T Fingerprint]() int {
+	var fp T
+	return int(unsafe.Sizeof(fp))
+}
+
+func serializeFingerprints[T Fingerprint](buf *bytes.Buffer, fingerprints []T) {
+	size := fingerprintSize[T]()
+	for _, fp := range fingerprints {
+		filter.SerializeUint(buf, uint64(fp), size)
+	}
+}
+
+func deserializeFingerprints[T Fingerprint](buf *bytes.Buffer, n int) []T {
+	size := fingerprintSize[T]()
+	fingerprints := make([]T, n)
+	for i := range fingerprints {
+		fingerprints[i] = T(filter.DeserializeUint[uint64](buf, size))
+	}
+	return fingerprints
+}
@@ -0,0 +1,167 @@
+package xorfilter_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rag-nar1/Filters/filter/xorfilter"
+)
+
+// staticFilter is the api shared by every filter of the package
+type staticFilter interface {
+	Populate(keys [][]byte) error
+	PopulateUint64(keys []uint64) error
+	Contains(data []byte) bool
+	ContainsUint64(key uint64) bool
+	Serialize() []byte
+}
+
+func newFilters() map[string]staticFilter {
+	return map[string]staticFilter{
+		"Xor8":         &xorfilter.Xor8{},
+		"Xor16":        &xorfilter.Xor16{},
+		"BinaryFuse8":  &xorfilter.BinaryFuse8{},
+		"BinaryFuse16": &xorfilter.BinaryFuse16{},
+	}
+}
+
+func generateKeys(prefix string, n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%s_%d", prefix, i))
+	}
+	return keys
+}
+
+func TestPopulateAndContains(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 10, 100, 1000, 100000} {
+		keys := generateKeys("item", n)
+		for name, f := range newFilters() {
+			t.Run(fmt.Sprintf("%s_%d", name, n), func(t *testing.T) {
+				if err := f.Populate(keys); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				for _, key := range keys {
+					if !f.Contains(key) {
+						t.Fatalf("false negative for %s", key)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPopulateUint64(t *testing.T) {
+	keys := make([]uint64, 10000)
+	for i := range keys {
+		keys[i] = uint64(i)
+	}
+	for name, f := range newFilters() {
+		t.Run(name, func(t *testing.T) {
+			if err := f.PopulateUint64(keys); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, key := range keys {
+				if !f.ContainsUint64(key) {
+					t.Fatalf("false negative for %d", key)
+				}
+			}
+		})
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	keys := generateKeys("item", 1000)
+	keys = append(keys, keys[:500]...)
+	for name, f := range newFilters() {
+		t.Run(name, func(t *testing.T) {
+			if err := f.Populate(keys); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, key := range keys {
+				if !f.Contains(key) {
+					t.Fatalf("false negative for %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	n := 100000
+	keys := generateKeys("inserted", n)
+	others := generateKeys("not_inserted", n)
+	expected := map[string]float64{
+		"Xor8":         1.0 / 256,
+		"Xor16":        1.0 / 65536,
+		"BinaryFuse8":  1.0 / 256,
+		"BinaryFuse16": 1.0 / 65536,
+	}
+
+	for name, f := range newFilters() {
+		t.Run(name, func(t *testing.T) {
+			if err := f.Populate(keys); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			falsePositives := 0
+			for _, key := range others {
+				if f.Contains(key) {
+					falsePositives++
+				}
+			}
+			rate := float64(falsePositives) / float64(n)
+			if rate > 2*expected[name]+0.0001 {
+				t.Errorf("false positive rate too high: %f (expected ~%f)", rate, expected[name])
+			}
+			t.Logf("false positive rate: %f, bits per key: %.2f", rate, float64(len(f.Serialize())*8)/float64(n))
+		})
+	}
+}
+
+func TestSerializeDeserialize(t *testing.T) {
+	keys := generateKeys("item", 10000)
+	others := generateKeys("other", 10000)
+	deserialize := map[string]func([]byte) staticFilter{
+		"Xor8":         func(b []byte) staticFilter { return xorfilter.DeserializeXor8(b) },
+		"Xor16":        func(b []byte) staticFilter { return xorfilter.DeserializeXor16(b) },
+		"BinaryFuse8":  func(b []byte) staticFilter { return xorfilter.DeserializeBinaryFuse8(b) },
+		"BinaryFuse16": func(b []byte) staticFilter { return xorfilter.DeserializeBinaryFuse16(b) },
+	}
+
+	for name, f := range newFilters() {
+		t.Run(name, func(t *testing.T) {
+			if err := f.Populate(keys); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			deserialized := deserialize[name](f.Serialize())
+			for _, key := range keys {
+				if !deserialized.Contains(key) {
+					t.Fatalf("false negative for %s after deserialization", key)
+				}
+			}
+			for _, key := range others {
+				if f.Contains(key) != deserialized.Contains(key) {
+					t.Fatalf("deserialized filter disagrees on %s", key)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkContains(b *testing.B) {
+	n := 1000000
+	keys := generateKeys("item", n)
+	for name, f := range newFilters() {
+		b.Run(name, func(b *testing.B) {
+			if err := f.Populate(keys); err != nil {
+				b.Fatalf("unexpected error: %v", err)
+			}
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				f.Contains(keys[i%n])
+			}
+			b.ReportMetric(float64(len(f.Serialize())*8)/float64(n), "bits_per_key")
+		})
+	}
+}
@@ -4,6 +4,7 @@ go 1.22.2
 
 require (
 	github.com/bits-and-blooms/bloom/v3 v3.7.0
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/dgryski/go-metro v0.0.0-20250106013310-edb8663e5e33
 	github.com/zeebo/xxh3 v1.0.2
 )
@@ -37,7 +37,7 @@ func main() {
 
 	fn, fp := 0, 0
 	for s, _ := range randData {
-		if !bf.Exist([]byte(s)) {
+		if !bf.ExistString(s) {
 			fn++
 		}
 	}